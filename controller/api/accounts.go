@@ -4,37 +4,48 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"strings"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
 	"github.com/nicle-lin/lillian/helper/auth"
-	"io"
 )
 
 func (a *Api) accounts(w http.ResponseWriter, r *http.Request) {
-	io.WriteString(w, "this is version 2"+"URL"+r.URL.String())
-	w.Header().Set("content-type", "application/json")
-
 	accounts, err := a.manager.Accounts()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	if err := json.NewEncoder(w).Encode(accounts); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+	if exportFormat(r) != "" {
+		rows := make([][]string, 0, len(accounts))
+		for _, acct := range accounts {
+			rows = append(rows, []string{acct.ID, acct.Username, acct.FirstName, acct.LastName, acct.Provider,
+				strings.Join(acct.Roles, ",")})
+		}
+		a.writeExport(w, r, "accounts", []string{"id", "username", "first_name", "last_name", "provider", "roles"}, sliceRows(rows))
 		return
 	}
+
+	// never return password hashes
+	for _, acct := range accounts {
+		acct.Password = ""
+	}
+
+	writeJSON(w, accounts)
 }
 
 func (a *Api) saveAccount(w http.ResponseWriter, r *http.Request) {
 	var account *auth.Account
 	if err := json.NewDecoder(r.Body).Decode(&account); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeBadRequest(w, r, err)
 		return
 	}
 
 	if err := a.manager.SaveAccount(account); err != nil {
 		log.Errorf("error saving account: %s", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -48,15 +59,13 @@ func (a *Api) account(w http.ResponseWriter, r *http.Request) {
 
 	account, err := a.manager.Account(username)
 	if err != nil {
-		log.Errorf("error deleting account: %s", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Errorf("error getting account: %s", err)
+		writeError(w, r, err)
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(account); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	account.Password = ""
+	writeJSON(w, account)
 }
 func (a *Api) deleteAccount(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -65,12 +74,12 @@ func (a *Api) deleteAccount(w http.ResponseWriter, r *http.Request) {
 	account, err := a.manager.Account(username)
 	if err != nil {
 		log.Errorf("error deleting account: %s", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	if err := a.manager.DeleteAccount(account); err != nil {
 		log.Errorf("error deleting account: %s", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
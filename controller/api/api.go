@@ -1,19 +1,36 @@
 package api
 
 import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
 	log "github.com/Sirupsen/logrus"
-	"github.com/gorilla/context"
+	gcontext "github.com/gorilla/context"
 	"github.com/gorilla/mux"
 	"github.com/nicle-lin/lillian/controller/manager"
 	"github.com/nicle-lin/lillian/controller/middleware/access"
 	"github.com/nicle-lin/lillian/controller/middleware/audit"
 	"github.com/nicle-lin/lillian/controller/middleware/auth"
+	"github.com/nicle-lin/lillian/controller/middleware/cors"
+	"github.com/nicle-lin/lillian/controller/middleware/requestid"
+	"github.com/nicle-lin/lillian/controller/static"
+	"github.com/nicle-lin/lillian/helper/auth/oidc"
+	"github.com/nicle-lin/lillian/helper/metrics"
 	"github.com/nicle-lin/lillian/helper/tlsutils"
 	"github.com/urfave/negroni"
 	"io/ioutil"
 	"net/http"
 )
 
+const tlsReloadInterval = 30 * time.Second
+
 type Api struct {
 	listenAddr         string
 	manager            manager.Manager
@@ -21,7 +38,16 @@ type Api struct {
 	tlsCACertPath      string
 	tlsCertPath        string
 	tlsKeyPath         string
+	tlsVerifyClients   bool
 	allowInsecure      bool
+	oidc               *oidc.OidcAuthenticator
+	cors               cors.Config
+	frontend           fs.FS
+	metricsToken       string
+	mu                 sync.Mutex
+	server             *http.Server
+	stop               chan struct{}
+	stopOnce           sync.Once
 }
 
 type ApiConfig struct {
@@ -31,21 +57,40 @@ type ApiConfig struct {
 	TLSCACertPath      string
 	TLSCertPath        string
 	TLSKeyPath         string
+	TLSVerifyClients   bool
 	AllowInsecure      bool
+	// OIDC enables login through an OpenID Connect provider when set
+	OIDC *oidc.OidcAuthenticator
+	// CORS lets the configured origins call the api from the browser
+	CORS cors.Config
+	// FrontendDir serves the frontend from a directory instead of the
+	// build embedded in the binary
+	FrontendDir string
+	// MetricsToken is the bearer token /metrics requires; /metrics is not
+	// served when it is empty
+	MetricsToken string
 }
 
 type Credentials struct {
 	Username string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
+	// OTP is the two-factor code or a recovery code
+	OTP string `json:"otp,omitempty"`
 }
 
-func writeCorsHeaders(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept")
-	w.Header().Add("Access-Control-Allow-Methods", "GET, POST, DELETE, PUT, OPTIONS")
+// currentUsername returns the user an authenticated request was made
+// by. The auth middleware has already verified the credentials.
+func currentUsername(r *http.Request) string {
+	return auth.Username(r)
 }
 
 func NewApi(config ApiConfig) *Api {
+	frontend := static.Embedded()
+	if config.FrontendDir != "" {
+		log.Infof("serving frontend from %s", config.FrontendDir)
+		frontend = static.Dir(config.FrontendDir)
+	}
+
 	return &Api{
 		listenAddr:         config.ListenAddr,
 		manager:            config.Manager,
@@ -53,7 +98,13 @@ func NewApi(config ApiConfig) *Api {
 		tlsCACertPath:      config.TLSCACertPath,
 		tlsCertPath:        config.TLSCertPath,
 		tlsKeyPath:         config.TLSKeyPath,
+		tlsVerifyClients:   config.TLSVerifyClients,
 		allowInsecure:      config.AllowInsecure,
+		oidc:               config.OIDC,
+		cors:               config.CORS,
+		frontend:           frontend,
+		metricsToken:       config.MetricsToken,
+		stop:               make(chan struct{}),
 	}
 }
 
@@ -62,10 +113,8 @@ func (a *Api) Run() error {
 	controllerManager := a.manager
 
 	apiRouter := mux.NewRouter()
-	apiRouter.HandleFunc("/api/accounts", a.accounts).Methods("GET")
-	apiRouter.HandleFunc("/api/accounts", a.saveAccount).Methods("POST")
-	apiRouter.HandleFunc("/api/accounts/{username}", a.account).Methods("GET")
-	apiRouter.HandleFunc("/api/accounts/{username}", a.deleteAccount).Methods("DELETE")
+	registerRoutes(apiRouter, a.routes())
+	requestIDs := requestid.NewRequestID()
 
 	auditExcludes := []string{
 		"/networks",
@@ -77,6 +126,7 @@ func (a *Api) Run() error {
 	apiAccessRequired := access.NewAccessRequired(controllerManager)
 	apiAuditor := audit.NewAuditor(controllerManager, auditExcludes)
 
+	apiAuthRouter.Use(instrument(apiRouter))
 	apiAuthRouter.Use(negroni.HandlerFunc(apiAuthRequired.HandlerFuncWithNext))
 	apiAuthRouter.Use(negroni.HandlerFunc(apiAccessRequired.HandlerFuncWithNext))
 	apiAuthRouter.Use(negroni.HandlerFunc(apiAuthRequired.HandlerFuncWithNext))
@@ -85,16 +135,47 @@ func (a *Api) Run() error {
 	apiAuthRouter.UseHandler(apiRouter)
 	globalMux.Handle("/api/", apiAuthRouter)
 
+	// login, docs and probes are served without authentication, metrics
+	// need their own token
+	loginRouter := mux.NewRouter()
+	registerRoutes(loginRouter, a.publicRoutes())
+	for _, prefix := range []string{apiV1Prefix, apiPrefix} {
+		globalMux.Handle(prefix+"/login", loginRouter)
+		globalMux.Handle(prefix+"/auth/", loginRouter)
+		globalMux.Handle(prefix+"/openapi.json", loginRouter)
+		globalMux.Handle(prefix+"/docs", loginRouter)
+	}
+	globalMux.HandleFunc("/healthz", a.healthz)
+	globalMux.HandleFunc("/readyz", a.readyz)
+	globalMux.Handle("/metrics", a.metrics(metrics.Default.Handler()))
+
+	// everything else is the frontend
+	globalMux.Handle("/", static.Handler(a.frontend))
+
+	// every request gets an id first, so errors can be traced to the log
+	// even when cors rejects them; cors wraps every route, so preflight
+	// requests are answered before they reach the auth middleware
+	globalRouter := negroni.New()
+	globalRouter.Use(negroni.HandlerFunc(requestIDs.HandlerFuncWithNext))
+	if len(a.cors.AllowedOrigins) > 0 {
+		globalRouter.Use(negroni.HandlerFunc(cors.NewCORS(a.cors).HandlerFuncWithNext))
+	}
+	globalRouter.UseHandler(globalMux)
+	handler := http.Handler(globalRouter)
+
 	s := &http.Server{
 		Addr:    a.listenAddr,
-		Handler: context.ClearHandler(globalMux),
+		Handler: gcontext.ClearHandler(handler),
 	}
+	a.mu.Lock()
+	a.server = s
+	a.mu.Unlock()
 
 	log.Printf("listening on %s\n", a.listenAddr)
 
 	//if user config tls
 	if a.tlsCertPath != "" && a.tlsKeyPath != "" {
-		log.Infof("using TLS for communication:cert=%s key=%s", a.tlsCertPath, a.tlsKeyPath)
+		log.Infof("using TLS for communication:cert=%s key=%s verifyClients=%v", a.tlsCertPath, a.tlsKeyPath, a.tlsVerifyClients)
 
 		var caCert []byte
 		if a.tlsCACertPath != "" {
@@ -114,12 +195,70 @@ func (a *Api) Run() error {
 		if err != nil {
 			return err
 		}
-		tlsConfig, err := tlsutils.GetServerTLSConfig(caCert, serverCert, serverKey, a.allowInsecure)
+		tlsConfig, err := tlsutils.GetServerTLSConfig(caCert, serverCert, serverKey, a.allowInsecure, a.tlsVerifyClients)
+		if err != nil {
+			return err
+		}
+
+		// serve the certificate through the reloader so it can be rotated
+		// by replacing the files or sending SIGHUP
+		reloader, err := tlsutils.NewCertificateReloader(a.tlsCertPath, a.tlsKeyPath)
 		if err != nil {
 			return err
 		}
+		reloader.OnReload = a.logCertificateReload
+		tlsConfig.Certificates = nil
+		tlsConfig.GetCertificate = reloader.GetCertificate
+
+		go reloader.Watch(tlsReloadInterval, a.stop)
+		go func() {
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			defer signal.Stop(hup)
+			for {
+				select {
+				case <-hup:
+					log.Info("received SIGHUP, reloading tls certificate")
+					reloader.Reload()
+				case <-a.stop:
+					return
+				}
+			}
+		}()
+
 		s.TLSConfig = tlsConfig
-		return s.ListenAndServeTLS(a.tlsCertPath, a.tlsKeyPath)
+		return ignoreServerClosed(s.ListenAndServeTLS("", ""))
+	}
+	return ignoreServerClosed(s.ListenAndServe())
+}
+
+func ignoreServerClosed(err error) error {
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests
+// until ctx is done. Background workers started by Run are stopped too.
+// It is safe to call more than once, e.g. on a second signal.
+func (a *Api) Shutdown(ctx context.Context) error {
+	a.stopOnce.Do(func() { close(a.stop) })
+
+	a.mu.Lock()
+	s := a.server
+	a.mu.Unlock()
+	if s == nil {
+		return nil
+	}
+	return s.Shutdown(ctx)
+}
+
+func (a *Api) logCertificateReload(leaf *x509.Certificate, err error) {
+	if err != nil {
+		a.manager.LogEvent("tls-reload-failed", fmt.Sprintf("cert=%s error=%s", a.tlsCertPath, err), []string{"tls"})
+		return
 	}
-	return s.ListenAndServe()
+	a.manager.LogEvent("tls-reload", fmt.Sprintf("cert=%s serial=%s subject=%s expires=%s", a.tlsCertPath,
+		leaf.SerialNumber, leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339)), []string{"tls"})
 }
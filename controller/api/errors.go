@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nicle-lin/lillian/controller/manager"
+	"github.com/nicle-lin/lillian/controller/middleware/requestid"
+	"github.com/nicle-lin/lillian/controller/response"
+	"github.com/nicle-lin/lillian/helper/auth"
+)
+
+var (
+	errOIDCDisabled     = errors.New("没有配置 OIDC 登陆")
+	errInvalidOIDCState = errors.New("无效的登陆状态, 请重新登陆")
+	errJSONRequired     = errors.New("需要 application/json")
+)
+
+// apiError is how an error of the manager is reported to clients
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+var apiErrors = map[error]apiError{
+	manager.ErrLoginFailure:               {http.StatusForbidden, "login_failed", "invalid username or password"},
+	manager.ErrLoginLocked:                {http.StatusTooManyRequests, "login_locked", "too many failed logins, try again later"},
+	manager.ErrPasswordChangeNotSupported: {http.StatusForbidden, "password_change_not_supported", "the password is managed by an external provider"},
+	manager.ErrAccountProviderMismatch:    {http.StatusForbidden, "account_provider_mismatch", "the account belongs to another provider"},
+	manager.ErrOTPRequired:                {http.StatusForbidden, "otp_required", "a two-factor code is required"},
+	manager.ErrInvalidOTP:                 {http.StatusForbidden, "invalid_otp", "invalid two-factor code"},
+	manager.ErrTOTPAlreadyEnabled:         {http.StatusConflict, "totp_already_enabled", "two-factor authentication is already enabled"},
+	manager.ErrTOTPNotEnrolled:            {http.StatusConflict, "totp_not_enrolled", "no two-factor secret, enroll first"},
+	manager.ErrTOTPEnrollmentRequired:     {http.StatusForbidden, "totp_enrollment_required", "two-factor authentication has to be enabled first"},
+	manager.ErrPasswordChangeRequired:     {http.StatusForbidden, "password_change_required", "the password has to be changed first"},
+	manager.ErrAccountExists:              {http.StatusConflict, "account_exists", "account already exists"},
+	manager.ErrInvalidAccount:             {http.StatusBadRequest, "invalid_account", "invalid account"},
+	manager.ErrAccountDoesNotExist:        {http.StatusNotFound, "account_not_found", "account not found"},
+	manager.ErrRoleDoesNotExist:           {http.StatusNotFound, "role_not_found", "role not found"},
+	manager.ErrNodeDoesNotExist:           {http.StatusNotFound, "node_not_found", "node not found"},
+	manager.ErrServiceKeyDoesNotExist:     {http.StatusNotFound, "service_key_not_found", "service key not found"},
+	manager.ErrInvalidAuthToken:           {http.StatusUnauthorized, "invalid_auth_token", "invalid auth token"},
+	manager.ErrJWTDisabled:                {http.StatusNotFound, "jwt_disabled", "bearer tokens are not enabled"},
+	manager.ErrInvalidRefreshToken:        {http.StatusUnauthorized, "invalid_refresh_token", "invalid refresh token"},
+	manager.ErrExtensionDoesNotExist:      {http.StatusNotFound, "extension_not_found", "extension not found"},
+	manager.ErrInvalidExtension:           {http.StatusBadRequest, "invalid_extension", "invalid extension"},
+	manager.ErrWebhookKeyDoesNotExist:     {http.StatusNotFound, "webhook_key_not_found", "webhook key not found"},
+	manager.ErrRegistryDoesNotExist:       {http.StatusNotFound, "registry_not_found", "registry not found"},
+	manager.ErrConsoleSessionDoesNotExist: {http.StatusNotFound, "console_session_not_found", "console session not found"},
+	manager.ErrSessionsDisabled:           {http.StatusNotFound, "sessions_disabled", "sessions are not enabled"},
+	manager.ErrSessionDoesNotExist:        {http.StatusNotFound, "session_not_found", "session not found or expired"},
+	manager.ErrInvalidCSRFToken:           {http.StatusForbidden, "invalid_csrf_token", "invalid csrf token"},
+	errOIDCDisabled:                       {http.StatusNotFound, "oidc_disabled", "oidc login is not enabled"},
+	errInvalidOIDCState:                   {http.StatusBadRequest, "invalid_oidc_state", "invalid login state, log in again"},
+	errJSONRequired:                       {http.StatusUnsupportedMediaType, "unsupported_media_type", "application/json is required"},
+}
+
+// fieldErrors names the request fields an error is about
+var fieldErrors = map[error][]response.FieldError{
+	manager.ErrInvalidAccount: {
+		{Field: "username", Message: "is required"},
+	},
+	manager.ErrInvalidExtension: {
+		{Field: "name", Message: "is required"},
+		{Field: "url", Message: "has to be an http(s) url"},
+	},
+}
+
+// writeError reports err in the error envelope. Known errors keep their
+// status; anything else is logged with the request id and reported as
+// an internal error without details.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if e, ok := apiErrors[err]; ok {
+		response.WriteError(w, r, e.status, &response.Error{
+			Code:             e.code,
+			Message:          e.message,
+			LocalizedMessage: err.Error(),
+			Fields:           fieldErrors[err],
+		})
+		return
+	}
+
+	if pe, ok := err.(*auth.PasswordError); ok {
+		response.WriteError(w, r, http.StatusBadRequest, &response.Error{
+			Code:             "invalid_password",
+			Message:          "the password does not satisfy the password policy",
+			LocalizedMessage: pe.Error(),
+			Fields:           []response.FieldError{{Field: "password", Message: pe.Error()}},
+		})
+		return
+	}
+
+	log.Errorf("internal error: request_id=%s path=%s: %s", requestid.FromRequest(r), r.URL.Path, err)
+	response.WriteError(w, r, http.StatusInternalServerError, &response.Error{
+		Code:             "internal_error",
+		Message:          "internal server error",
+		LocalizedMessage: "服务器内部错误",
+	})
+}
+
+// writeStatusError reports an error that has no entry in apiErrors
+func writeStatusError(w http.ResponseWriter, r *http.Request, status int, code, message string, err error) {
+	response.WriteError(w, r, status, &response.Error{
+		Code:             code,
+		Message:          message,
+		LocalizedMessage: err.Error(),
+	})
+}
+
+// writeBadRequest reports a request body or query that could not be read
+func writeBadRequest(w http.ResponseWriter, r *http.Request, err error) {
+	writeStatusError(w, r, http.StatusBadRequest, "invalid_request", "invalid request: "+err.Error(), err)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	response.JSON(w, http.StatusOK, v)
+}
@@ -1,15 +1,68 @@
 package api
 
-import "net/http"
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nicle-lin/lillian/model"
+)
+
+const (
+	defaultEventsLimit = 100
+	// maxEventsLimit bounds a single list or export
+	maxEventsLimit = 10000
+)
 
 func (a *Api) events(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("content-type", "application/json")
-	//TODO: show all event
+	limit := defaultEventsLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		i, err := strconv.Atoi(l)
+		if err != nil {
+			writeBadRequest(w, r, err)
+			return
+		}
+		if i <= 0 {
+			writeBadRequest(w, r, errors.New("limit has to be positive"))
+			return
+		}
+		limit = i
+	}
+	if limit > maxEventsLimit {
+		limit = maxEventsLimit
+	}
+
+	// optional filter by event type
+	eventType := r.URL.Query().Get("type")
+	if exportFormat(r) != "" {
+		a.writeExport(w, r, "events", []string{"time", "type", "username", "message", "tags"},
+			func(write func(row []string) error) error {
+				return a.manager.EachEvent(eventType, limit, func(evt *model.Event) error {
+					return write([]string{evt.Time.Format(time.RFC3339), evt.Type, evt.Username, evt.Message, strings.Join(evt.Tags, ",")})
+				})
+			})
+		return
+	}
+
+	events, err := a.manager.Events(eventType, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, events)
 }
 
 func (a *Api) purgeEvents(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("content-type", "application/json")
-	//TODO: purge all event
+	if err := a.manager.PurgeEvents(); err != nil {
+		log.Errorf("error purging events: %s", err)
+		writeError(w, r, err)
+		return
+	}
 
+	log.Info("cleared events")
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nicle-lin/lillian/controller/manager"
+	"github.com/nicle-lin/lillian/model"
+)
+
+// eventsManager streams two events and remembers the requested limit;
+// other methods are not used by the tests and panic
+type eventsManager struct {
+	manager.Manager
+	limit int
+}
+
+func (m *eventsManager) EachEvent(eventType string, limit int, fn func(*model.Event) error) error {
+	m.limit = limit
+	for _, msg := range []string{"first", "=cmd|' /C calc'!A0"} {
+		if err := fn(&model.Event{Type: "login", Time: time.Unix(0, 0).UTC(), Message: msg}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *eventsManager) LogEvent(eventType, message string, tags []string) {}
+
+func TestEventsExport(t *testing.T) {
+	m := &eventsManager{}
+	_, router := newTestApi(m)
+
+	req, _ := http.NewRequest("GET", "/api/v1/events?format=csv&limit=1000000", nil)
+	res := serveTest(router, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected 200; got %d", res.Code)
+	}
+	if m.limit != maxEventsLimit {
+		t.Fatalf("expected limit clamped to %d; received %d", maxEventsLimit, m.limit)
+	}
+	body := res.Body.String()
+	if !strings.Contains(body, "login,,first,") || !strings.Contains(body, "'=cmd") {
+		t.Fatalf("expected both events with escaped formulas:\n%s", body)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/events?format=csv&limit=0", nil)
+	if res := serveTest(router, req); res.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for limit=0; got %d", res.Code)
+	}
+}
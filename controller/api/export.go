@@ -0,0 +1,80 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nicle-lin/lillian/helper/export"
+)
+
+// exportFormat returns the requested export format for a list query or
+// an empty string when the list should be returned as JSON
+func exportFormat(r *http.Request) string {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		return ""
+	default:
+		return format
+	}
+}
+
+// exportRows calls write with each row of an export. The rows are
+// written to the response as they come, so a list does not have to be
+// held in memory.
+type exportRows func(write func(row []string) error) error
+
+// sliceRows exports rows that are already in memory
+func sliceRows(rows [][]string) exportRows {
+	return func(write func(row []string) error) error {
+		for _, row := range rows {
+			if err := write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// writeExport streams rows as a file download in the requested format.
+// It is called by the list handlers after they applied their own filters,
+// so an export never contains more than the JSON response would.
+func (a *Api) writeExport(w http.ResponseWriter, r *http.Request, list string, columns []string, rows exportRows) {
+	format := exportFormat(r)
+	filename := fmt.Sprintf("%s-%s.%s", list, time.Now().Format("20060102150405"), format)
+
+	w.Header().Set("content-type", export.ContentType(format))
+	w.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	ew, err := export.NewWriter(format, w)
+	if err != nil {
+		w.Header().Del("content-disposition")
+		w.Header().Del("content-type")
+		writeStatusError(w, r, http.StatusBadRequest, "invalid_export_format", "unsupported export format", err)
+		return
+	}
+
+	if err := ew.WriteHeader(columns); err != nil {
+		log.Errorf("error exporting %s: %s", list, err)
+		return
+	}
+	// the status has been sent with the first bytes, errors from here on
+	// can only be logged and leave a truncated file
+	n := 0
+	if err := rows(func(row []string) error {
+		n++
+		return ew.WriteRow(row)
+	}); err != nil {
+		log.Errorf("error exporting %s: %s", list, err)
+		return
+	}
+	if err := ew.Close(); err != nil {
+		log.Errorf("error exporting %s: %s", list, err)
+		return
+	}
+
+	username := currentUsername(r)
+	a.manager.LogEvent("export", fmt.Sprintf("list=%s format=%s rows=%d username=%s", list, format, n, username),
+		[]string{"export", list})
+}
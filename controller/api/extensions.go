@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/nicle-lin/lillian/controller/manager"
+	"github.com/nicle-lin/lillian/model"
+)
+
+func (a *Api) extensions(w http.ResponseWriter, r *http.Request) {
+	exts, err := a.manager.Extensions()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, exts)
+}
+
+// savedExtension is the response to saving an extension, the only one
+// that contains the service key; it has to be configured in the
+// extension so it can check that requests come from lillian. Calls the
+// extension makes with it run as manager.ExtensionUsername and only get
+// the roles of an account of that name.
+type savedExtension struct {
+	*model.Extension
+	ServiceKey string `json:"service_key"`
+}
+
+func (a *Api) saveExtension(w http.ResponseWriter, r *http.Request) {
+	var ext model.Extension
+	if err := json.NewDecoder(r.Body).Decode(&ext); err != nil {
+		writeBadRequest(w, r, err)
+		return
+	}
+	if ext.Name == "" || ext.URL == "" {
+		writeError(w, r, manager.ErrInvalidExtension)
+		return
+	}
+
+	if err := a.manager.SaveExtension(&ext); err != nil {
+		log.Errorf("error saving extension: %s", err)
+		writeError(w, r, err)
+		return
+	}
+
+	log.Debugf("saved extension: name=%s url=%s", ext.Name, ext.URL)
+	writeJSON(w, &savedExtension{Extension: &ext, ServiceKey: ext.ServiceKey})
+}
+
+func (a *Api) extension(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	ext, err := a.manager.Extension(name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, ext)
+}
+
+func (a *Api) deleteExtension(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	ext, err := a.manager.Extension(name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := a.manager.DeleteExtension(ext); err != nil {
+		log.Errorf("error deleting extension: %s", err)
+		writeError(w, r, err)
+		return
+	}
+
+	log.Infof("deleted extension: name=%s id=%s", ext.Name, ext.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// extensionPrefix returns the part of the path the proxy strips, which
+// depends on whether the extension was called through /api or /api/v1
+func extensionPrefix(path, name string) string {
+	prefix := "/api/extensions/" + name
+	if strings.HasPrefix(path, apiV1Prefix+"/") {
+		prefix = apiV1Prefix + "/extensions/" + name
+	}
+	return prefix
+}
+
+// proxyExtension forwards /api/extensions/{name}/... to the extension's URL.
+// The caller's credentials are stripped and replaced by the extension's
+// service key, with the calling user passed along in X-Lillian-Username.
+func (a *Api) proxyExtension(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	ext, err := a.manager.Extension(name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	target, err := url.Parse(ext.URL)
+	if err != nil {
+		log.Errorf("invalid url for extension %s: %s", ext.Name, err)
+		writeStatusError(w, r, http.StatusBadGateway, "extension_unavailable", "the extension is not available", err)
+		return
+	}
+
+	username := currentUsername(r)
+
+	prefix := extensionPrefix(r.URL.Path, ext.Name)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		req.URL.Path = strings.TrimPrefix(req.URL.Path, prefix)
+		req.URL.RawPath = ""
+		director(req)
+		req.Host = target.Host
+		req.Header.Del("X-Access-Token")
+		req.Header.Del("Authorization")
+		req.Header.Del("Cookie")
+		req.Header.Set("X-Service-Key", ext.ServiceKey)
+		req.Header.Set("X-Lillian-Username", username)
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		log.Errorf("error proxying to extension %s: %s", ext.Name, err)
+		writeStatusError(w, req, http.StatusBadGateway, "extension_unavailable", "the extension is not available", err)
+	}
+	proxy.ServeHTTP(w, r)
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/nicle-lin/lillian/controller/manager"
+	"github.com/nicle-lin/lillian/model"
+)
+
+// testManager keeps extensions in memory; other methods are not used by
+// the tests and panic
+type testManager struct {
+	manager.Manager
+	extensions map[string]*model.Extension
+}
+
+func (m *testManager) Extensions() ([]*model.Extension, error) {
+	exts := []*model.Extension{}
+	for _, ext := range m.extensions {
+		exts = append(exts, ext)
+	}
+	return exts, nil
+}
+
+func (m *testManager) Extension(name string) (*model.Extension, error) {
+	ext, ok := m.extensions[name]
+	if !ok {
+		return nil, manager.ErrExtensionDoesNotExist
+	}
+	return ext, nil
+}
+
+func (m *testManager) SaveExtension(ext *model.Extension) error {
+	ext.ServiceKey = "generated-key"
+	m.extensions[ext.Name] = ext
+	return nil
+}
+
+func newTestApi(m manager.Manager) (*Api, *mux.Router) {
+	a := &Api{manager: m}
+	router := mux.NewRouter()
+	registerRoutes(router, a.routes())
+	return a, router
+}
+
+func serveTest(router http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func TestProxyExtension(t *testing.T) {
+	var received *http.Request
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		w.Write([]byte("from extension"))
+	}))
+	defer backend.Close()
+
+	_, router := newTestApi(&testManager{extensions: map[string]*model.Extension{
+		"billing": {Name: "billing", URL: backend.URL, ServiceKey: "billing-key"},
+	}})
+
+	for _, prefix := range []string{"/api", "/api/v1"} {
+		req, _ := http.NewRequest("GET", prefix+"/extensions/billing/invoices?page=2", nil)
+		req.Header.Set("Authorization", "Bearer user-token")
+		req.Header.Set("X-Access-Token", "admin:token")
+		req.Header.Set("Cookie", "lilliansessionid=3f2a9c1b")
+		req.Header.Set("X-Service-Key", "caller-key")
+
+		res := serveTest(router, req)
+		if res.Code != http.StatusOK || res.Body.String() != "from extension" {
+			t.Fatalf("%s: expected proxied response; got %d %q", prefix, res.Code, res.Body.String())
+		}
+		if received.URL.Path != "/invoices" || received.URL.RawQuery != "page=2" {
+			t.Fatalf("%s: expected /invoices?page=2; got %s", prefix, received.URL)
+		}
+		for _, h := range []string{"Authorization", "X-Access-Token", "Cookie"} {
+			if v := received.Header.Get(h); v != "" {
+				t.Fatalf("%s: expected %s to be stripped; got %q", prefix, h, v)
+			}
+		}
+		if v := received.Header.Get("X-Service-Key"); v != "billing-key" {
+			t.Fatalf("%s: expected the extension's service key; got %q", prefix, v)
+		}
+	}
+}
+
+func TestExtensionServiceKeyHidden(t *testing.T) {
+	_, router := newTestApi(&testManager{extensions: map[string]*model.Extension{
+		"billing": {Name: "billing", URL: "https://billing.example.com", ServiceKey: "billing-key"},
+	}})
+
+	for _, path := range []string{"/api/v1/extensions", "/api/v1/extensions/billing"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		res := serveTest(router, req)
+		if res.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200; got %d", path, res.Code)
+		}
+		if strings.Contains(res.Body.String(), "billing-key") || strings.Contains(res.Body.String(), "service_key") {
+			t.Fatalf("%s: expected no service key; got %s", path, res.Body.String())
+		}
+	}
+
+	// the key is returned once, to whoever registers the extension
+	req, _ := http.NewRequest("POST", "/api/v1/extensions", strings.NewReader(`{"name": "crm", "url": "https://crm.example.com"}`))
+	res := serveTest(router, req)
+	var saved struct {
+		Name       string `json:"name"`
+		ServiceKey string `json:"service_key"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Name != "crm" || saved.ServiceKey != "generated-key" {
+		t.Fatalf("expected saved extension with service key; got %+v", saved)
+	}
+}
+
+func TestSaveExtensionInvalid(t *testing.T) {
+	_, router := newTestApi(&testManager{extensions: map[string]*model.Extension{}})
+
+	for _, body := range []string{"null", "{}", `{"name": "crm"}`, "not json"} {
+		req, _ := http.NewRequest("POST", "/api/v1/extensions", strings.NewReader(body))
+		if res := serveTest(router, req); res.Code != http.StatusBadRequest {
+			t.Fatalf("%q: expected 400; got %d", body, res.Code)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nicle-lin/lillian/controller/manager"
+	"github.com/nicle-lin/lillian/controller/response"
+	"github.com/nicle-lin/lillian/helper/metrics"
+	"github.com/urfave/negroni"
+)
+
+var (
+	requestsTotal = metrics.Default.NewCounterVec("lillian_http_requests_total",
+		"API requests by route, method and status code.", "route", "method", "code")
+	requestDuration = metrics.Default.NewHistogramVec("lillian_http_request_duration_seconds",
+		"API request latencies by route and method.", metrics.DefaultBuckets, "route", "method")
+)
+
+// healthz reports that the process is up and serving requests
+func (a *Api) healthz(w http.ResponseWriter, r *http.Request) {
+	response.JSON(w, http.StatusOK, map[string]string{"status": manager.NodeHealthUp})
+}
+
+// readyz reports whether mysql, redis and the session store are reachable
+func (a *Api) readyz(w http.ResponseWriter, r *http.Request) {
+	health := a.manager.Health()
+
+	status := http.StatusOK
+	for _, h := range health {
+		if h != manager.NodeHealthUp {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	response.JSON(w, status, health)
+}
+
+// metrics serves next only to requests carrying the configured metrics
+// token. Without a token the metrics are not served at all, since they
+// include login failures and lockouts.
+func (a *Api) metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.metricsToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		authorization := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		if token == authorization || subtle.ConstantTimeCompare([]byte(token), []byte(a.metricsToken)) != 1 {
+			response.Unauthorized(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// instrument records request counts and latencies per route template, so
+// /api/accounts/{username} is one series regardless of the username
+func instrument(router *mux.Router) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		start := time.Now()
+		next(w, r)
+
+		route := "unmatched"
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil {
+			if tpl, err := match.Route.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		code := http.StatusOK
+		if rw, ok := w.(negroni.ResponseWriter); ok && rw.Status() != 0 {
+			code = rw.Status()
+		}
+
+		requestsTotal.Inc(route, r.Method, strconv.Itoa(code))
+		requestDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsToken(t *testing.T) {
+	metrics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		token         string
+		authorization string
+		status        int
+	}{
+		{"", "", http.StatusNotFound},
+		{"", "Bearer ", http.StatusNotFound},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "secret", http.StatusUnauthorized},
+		{"secret", "Bearer secret", http.StatusOK},
+	}
+	for _, test := range tests {
+		a := &Api{metricsToken: test.token}
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		res := serveTest(a.metrics(metrics), req)
+		if res.Code != test.status {
+			t.Fatalf("expected %d for token %q and authorization %q; received %d", test.status, test.token, test.authorization, res.Code)
+		}
+	}
+}
+
+func TestShutdownTwice(t *testing.T) {
+	a := NewApi(ApiConfig{})
+	for i := 0; i < 2; i++ {
+		if err := a.Shutdown(context.Background()); err != nil {
+			t.Fatalf("expected no error on shutdown %d; received %v", i+1, err)
+		}
+	}
+}
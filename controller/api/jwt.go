@@ -0,0 +1,49 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nicle-lin/lillian/controller/manager"
+)
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// refreshToken trades a refresh token for a new access token. The
+// refresh token is replaced too and the old one can not be used again.
+func (a *Api) refreshToken(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBadRequest(w, r, err)
+		return
+	}
+
+	token, err := a.manager.RefreshJWT(req.RefreshToken, r.UserAgent())
+	if err != nil {
+		if err == manager.ErrInvalidRefreshToken {
+			log.Warnf("invalid refresh token from %s", r.RemoteAddr)
+		}
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, token)
+}
+
+// jwks publishes the keys access tokens are signed with, so other
+// services can verify them and pick up rotated keys
+func (a *Api) jwks(w http.ResponseWriter, r *http.Request) {
+	keys, err := a.manager.JWKS()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("content-type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, keys)
+}
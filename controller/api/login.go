@@ -2,93 +2,245 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	log "github.com/Sirupsen/logrus"
 	"github.com/nicle-lin/lillian/controller/manager"
+	"github.com/nicle-lin/lillian/controller/response"
 	"github.com/nicle-lin/lillian/helper/auth"
 	"github.com/nicle-lin/lillian/helper/auth/ldap"
+	"net"
 	"net/http"
+	"strings"
 )
 
 func (a *Api) register(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// remoteIP returns the client address without the port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func (a *Api) login(w http.ResponseWriter, r *http.Request) {
-	var creds *Credentials
-	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	username, ok := a.authenticateLogin(w, r)
+	if !ok {
 		return
 	}
 
-	loginSuccessful, err := a.manager.Authenticate(creds.Username, creds.Password)
+	// return token
+	token, err := a.newAuthToken(username, r)
 	if err != nil {
-		log.Errorf("登陆出错 %s from %s: %s", creds.Username, r.RemoteAddr, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	writeJSON(w, token)
+}
+
+// authenticateLogin checks the credentials of a login request, including
+// the second factor, and returns the username. Failures are written to w.
+func (a *Api) authenticateLogin(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var creds Credentials
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		writeBadRequest(w, r, err)
+		return "", false
+	}
+	if creds.Username == "" || creds.Password == "" {
+		writeBadRequest(w, r, errors.New("username and password are required"))
+		return "", false
+	}
+
+	ip := remoteIP(r)
+	if err := a.manager.CheckLogin(creds.Username, ip); err != nil {
+		log.Warnf("登陆被锁定 %s from %s", creds.Username, ip)
+		writeError(w, r, err)
+		return "", false
+	}
 
+	provider, err := a.manager.AuthenticateProvider(creds.Username, creds.Password)
+	loginSuccessful := err == nil
+	if err != nil && err != manager.ErrLoginFailure {
+		a.manager.ReleaseLogin(creds.Username, ip)
+		log.Errorf("登陆出错 %s from %s: %s", creds.Username, r.RemoteAddr, err)
+		writeError(w, r, err)
+		return "", false
+	}
+
+	// the second factor is only checked once the password is known to be
+	// right, so a missing code does not count as a failed login
+	if loginSuccessful {
+		if err := a.manager.VerifyTOTP(creds.Username, creds.OTP); err != nil {
+			switch err {
+			case manager.ErrOTPRequired:
+				a.manager.ReleaseLogin(creds.Username, ip)
+				w.Header().Set("X-Lillian-OTP", "required")
+				writeStatusError(w, r, http.StatusUnauthorized, "otp_required", "a two-factor code is required", err)
+				return "", false
+			case manager.ErrInvalidOTP:
+				loginSuccessful = false
+			default:
+				a.manager.ReleaseLogin(creds.Username, ip)
+				log.Errorf("登陆出错 %s from %s: %s", creds.Username, r.RemoteAddr, err)
+				writeError(w, r, err)
+				return "", false
+			}
+		}
+	}
+
+	a.manager.RecordLogin(creds.Username, ip, loginSuccessful)
 	if !loginSuccessful {
 		log.Warnf("无效的登陆r %s from %s", creds.Username, r.RemoteAddr)
-		http.Error(w, "无效的用户名和密码", http.StatusForbidden)
-		return
+		writeError(w, r, manager.ErrLoginFailure)
+		return "", false
 	}
 
-	// check for ldap and autocreate for users
-	if a.manager.GetAuthenticator().Name() == "ldap" {
-		if a.manager.GetAuthenticator().(*ldap.LdapAuthenticator).AutocreateUsers {
-			defaultAccessLevel := a.manager.GetAuthenticator().(*ldap.LdapAuthenticator).DefaultAccessLevel
-			log.Debug("ldap: checking for existing user account and creating if necessary")
-			// give default users readonly access to containers
-			acct := &auth.Account{
-				Username: creds.Username,
-				Roles:    []string{defaultAccessLevel},
+	// ldap users get their roles from directory groups at each login and
+	// an account on their first login if autocreate is enabled
+	if ldapAuth, ok := provider.(*ldap.LdapAuthenticator); ok {
+		roles, err := ldapAuth.Roles(creds.Username)
+		if err != nil {
+			log.Errorf("error looking up ldap groups for %s: %s", creds.Username, err)
+			writeError(w, r, err)
+			return "", false
+		}
+		syncRoles := roles != nil
+		if roles == nil && ldapAuth.DefaultAccessLevel != "" {
+			roles = []string{ldapAuth.DefaultAccessLevel}
+		}
+		if _, err := a.provisionAccount(creds.Username, ldapAuth.Name(), roles, ldapAuth.AutocreateUsers, syncRoles); err != nil {
+			if err == manager.ErrAccountDoesNotExist || err == manager.ErrAccountProviderMismatch {
+				log.Warnf("no account for ldap user %s: %s", creds.Username, err)
+				writeStatusError(w, r, http.StatusForbidden, "no_account", "no account for this user", err)
+				return "", false
 			}
+			log.Errorf("error provisioning ldap user %s: %s", creds.Username, err)
+			writeError(w, r, err)
+			return "", false
+		}
+	}
+	return creds.Username, true
+}
 
-			// check for existing account
-			if _, err := a.manager.Account(creds.Username); err != nil {
-				if err == manager.ErrAccountDoesNotExist {
-					log.Debugf("autocreating user for ldap: username=%s access=%s", creds.Username, defaultAccessLevel)
-					if err := a.manager.SaveAccount(acct); err != nil {
-						log.Errorf("error autocreating ldap user %s: %s", creds.Username, err)
-						http.Error(w, err.Error(), http.StatusInternalServerError)
-						return
-					}
-				} else {
-					log.Errorf("error checking user for autocreate: %s", err)
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-			}
+// provisionAccount returns the account of a user that was authenticated
+// by an external provider. Missing accounts are created with the roles
+// when autocreate is set; with syncRoles existing accounts get the roles
+// the provider reported, so group changes apply at the next login.
+// Accounts bound to another provider are rejected.
+func (a *Api) provisionAccount(username, provider string, roles []string, autocreate, syncRoles bool) (*auth.Account, error) {
+	acct, err := a.manager.Account(username)
+	if err == manager.ErrAccountDoesNotExist {
+		if !autocreate {
+			return nil, err
+		}
+		log.Debugf("autocreating user: username=%s provider=%s roles=%s", username, provider, strings.Join(roles, ","))
+		acct = &auth.Account{
+			Username: username,
+			Roles:    roles,
+			Provider: provider,
+		}
+		return acct, a.manager.SaveAccount(acct)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if acct.Provider != "" && acct.Provider != provider {
+		return nil, manager.ErrAccountProviderMismatch
+	}
 
+	if syncRoles && roles != nil && strings.Join(acct.Roles, ",") != strings.Join(roles, ",") {
+		log.Infof("updating roles from provider: username=%s roles=%s", username, strings.Join(roles, ","))
+		acct.Roles = roles
+		acct.Password = ""
+		acct.Provider = provider
+		if err := a.manager.SaveAccount(acct); err != nil {
+			return nil, err
 		}
 	}
+	return acct, nil
+}
 
-	// return token
-	token, err := a.manager.NewAuthToken(creds.Username, r.UserAgent())
+// newAuthToken issues the api tokens after a successful login
+func (a *Api) newAuthToken(username string, r *http.Request) (*auth.AuthToken, error) {
+	token, err := a.manager.NewAuthToken(username, r.UserAgent())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
-	if err := json.NewEncoder(w).Encode(token); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	// bearer tokens are issued alongside the legacy token when enabled
+	switch jt, err := a.manager.NewJWT(username, r.UserAgent()); err {
+	case nil:
+		token.AccessToken = jt.AccessToken
+		token.TokenType = jt.TokenType
+		token.ExpiresIn = jt.ExpiresIn
+		token.RefreshToken = jt.RefreshToken
+	case manager.ErrJWTDisabled:
+	default:
+		return nil, err
+	}
+	if acct, err := a.manager.Account(username); err == nil {
+		token.TOTPEnrollmentRequired = a.manager.TOTPRequired(acct)
+		token.PasswordChangeRequired = acct.PasswordChangeRequired
 	}
+	return token, nil
+}
+
+// passwordChange is the request to change the password of the current
+// user; the current password is required so a stolen session or token
+// is not enough to take the account over
+type passwordChange struct {
+	OldPassword string `json:"old_password"`
+	Password    string `json:"password"`
 }
 
 func (a *Api) changePassword(w http.ResponseWriter, r *http.Request) {
-	var creds *Credentials
-	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var change passwordChange
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		writeBadRequest(w, r, err)
+		return
+	}
+	if change.OldPassword == "" || change.Password == "" {
+		writeBadRequest(w, r, errors.New("old_password and password are required"))
 		return
 	}
-	username := a.manager.Store(w, r).Get("username").(string)
+	username := currentUsername(r)
 	if username == "" {
-		http.Error(w, "没有认证", http.StatusInternalServerError)
-		//http.Error(w, "unauthorized", http.StatusInternalServerError)
+		response.Unauthorized(w, r)
 		return
 	}
-	if err := a.manager.ChangePassword(username, creds.Password); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+	// a wrong current password counts like a failed login
+	ip := remoteIP(r)
+	if err := a.manager.CheckLogin(username, ip); err != nil {
+		log.Warnf("修改密码被锁定 %s from %s", username, ip)
+		writeError(w, r, err)
 		return
 	}
+	if _, err := a.manager.AuthenticateProvider(username, change.OldPassword); err != nil {
+		if err == manager.ErrLoginFailure {
+			a.manager.RecordLogin(username, ip, false)
+			log.Warnf("修改密码时当前密码错误 %s from %s", username, r.RemoteAddr)
+		} else {
+			a.manager.ReleaseLogin(username, ip)
+			log.Errorf("修改密码出错 %s from %s: %s", username, r.RemoteAddr, err)
+		}
+		writeError(w, r, err)
+		return
+	}
+	a.manager.ReleaseLogin(username, ip)
+
+	_, sessionErr := a.manager.CurrentSession(r)
+	if err := a.manager.ChangePassword(username, change.Password); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// changing the password ends every session; the browser that made the
+	// change gets a new one
+	if sessionErr == nil {
+		if _, err := a.manager.NewSession(w, r, username); err != nil {
+			log.Errorf("error renewing session for %s: %s", username, err)
+		}
+	}
 }
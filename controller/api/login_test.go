@@ -0,0 +1,114 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/nicle-lin/lillian/controller/manager"
+	"github.com/nicle-lin/lillian/controller/middleware/auth"
+	helperauth "github.com/nicle-lin/lillian/helper/auth"
+)
+
+// passwordManager knows the password of alice, who is authenticated by
+// the bearer token "alice"; other methods are not used by the tests and
+// panic
+type passwordManager struct {
+	manager.Manager
+	password string
+	failures int
+	changed  string
+}
+
+func (m *passwordManager) VerifyJWT(token string) (string, error) {
+	if token != "alice" {
+		return "", manager.ErrInvalidAuthToken
+	}
+	return token, nil
+}
+
+func (m *passwordManager) Account(username string) (*helperauth.Account, error) {
+	return nil, manager.ErrAccountDoesNotExist
+}
+
+func (m *passwordManager) CheckLogin(username, ip string) error {
+	if m.failures >= 3 {
+		return manager.ErrLoginLocked
+	}
+	return nil
+}
+
+func (m *passwordManager) AuthenticateProvider(username, password string) (helperauth.Authenticator, error) {
+	if password != m.password {
+		return nil, manager.ErrLoginFailure
+	}
+	return nil, nil
+}
+
+func (m *passwordManager) RecordLogin(username, ip string, success bool) {
+	if !success {
+		m.failures++
+	}
+}
+
+func (m *passwordManager) ReleaseLogin(username, ip string) {}
+
+func (m *passwordManager) CurrentSession(r *http.Request) (*manager.Session, error) {
+	return nil, manager.ErrSessionDoesNotExist
+}
+
+func (m *passwordManager) ChangePassword(username, password string) error {
+	m.changed = password
+	return nil
+}
+
+func TestChangePasswordRequiresOldPassword(t *testing.T) {
+	m := &passwordManager{password: "old-passw0rd"}
+	_, router := newTestApi(m)
+	handler := auth.NewAuthRequired(m, []string{}).Handler(router)
+
+	post := func(body string) int {
+		req, _ := http.NewRequest("POST", "/api/v1/account/changepassword", strings.NewReader(body))
+		req.RemoteAddr = "10.0.0.1:40000"
+		req.Header.Set("Authorization", "Bearer alice")
+		return serveTest(handler, req).Code
+	}
+
+	if code := post(`{"password": "n3w-passw0rd"}`); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without old_password; got %d", code)
+	}
+	if code := post(`null`); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a null body; got %d", code)
+	}
+	if code := post(`{"old_password": "wrong", "password": "n3w-passw0rd"}`); code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a wrong old password; got %d", code)
+	}
+	if m.changed != "" || m.failures != 1 {
+		t.Fatalf("expected one failure and no change; received failures=%d changed=%q", m.failures, m.changed)
+	}
+	if code := post(`{"old_password": "old-passw0rd", "password": "n3w-passw0rd"}`); code != http.StatusOK {
+		t.Fatalf("expected 200; got %d", code)
+	}
+	if m.changed != "n3w-passw0rd" {
+		t.Fatalf("expected the password to be changed; received %q", m.changed)
+	}
+
+	m.failures = 3
+	if code := post(`{"old_password": "old-passw0rd", "password": "an0ther-passw0rd"}`); code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 once locked; got %d", code)
+	}
+}
+
+func TestLoginRequiresCredentials(t *testing.T) {
+	a := &Api{manager: &passwordManager{password: "old-passw0rd"}}
+	router := mux.NewRouter()
+	registerRoutes(router, a.publicRoutes())
+
+	for _, body := range []string{`null`, `{}`, `{"username": "alice"}`, `{"password": "old-passw0rd"}`} {
+		req, _ := http.NewRequest("POST", "/api/v1/login", strings.NewReader(body))
+		if res := serveTest(router, req); res.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s; got %d", body, res.Code)
+		}
+	}
+}
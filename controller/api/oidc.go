@@ -0,0 +1,177 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nicle-lin/lillian/controller/manager"
+	"github.com/nicle-lin/lillian/helper/auth/oidc"
+)
+
+const (
+	oidcCookieName = "lillian_oidc"
+	// the login and the callback can be on /api or /api/v1
+	oidcCookiePath = "/api/"
+	// oidcLoginTimeout is how long users have to finish the login at the
+	// provider, in seconds
+	oidcLoginTimeout = 600
+)
+
+// oidcLoginState is kept in a short lived cookie between redirecting to
+// the provider and the callback
+type oidcLoginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+func (a *Api) setOIDCCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    value,
+		Path:     oidcCookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// the callback is a top level navigation from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (a *Api) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if a.oidc == nil {
+		writeError(w, r, errOIDCDisabled)
+		return
+	}
+
+	var st oidcLoginState
+	for _, v := range []*string{&st.State, &st.Nonce, &st.Verifier} {
+		s, err := oidc.RandomString()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		*v = s
+	}
+
+	authURL, err := a.oidc.AuthCodeURL(r.Context(), st.State, st.Nonce, st.Verifier)
+	if err != nil {
+		log.Errorf("oidc: error building login url: %s", err)
+		writeStatusError(w, r, http.StatusBadGateway, "oidc_provider_unavailable", "the oidc provider is not available", err)
+		return
+	}
+
+	data, err := json.Marshal(st)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	a.setOIDCCookie(w, r, base64.RawURLEncoding.EncodeToString(data), oidcLoginTimeout)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func readOIDCState(r *http.Request) (*oidcLoginState, bool) {
+	c, err := r.Cookie(oidcCookieName)
+	if err != nil {
+		return nil, false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil {
+		return nil, false
+	}
+	var st oidcLoginState
+	if err := json.Unmarshal(data, &st); err != nil || st.State == "" {
+		return nil, false
+	}
+	return &st, true
+}
+
+func (a *Api) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if a.oidc == nil {
+		writeError(w, r, errOIDCDisabled)
+		return
+	}
+
+	st, ok := readOIDCState(r)
+	// the state can only be used once
+	a.setOIDCCookie(w, r, "", -1)
+
+	if e := r.FormValue("error"); e != "" {
+		log.Warnf("oidc: provider rejected login: %s %s", e, r.FormValue("error_description"))
+		writeStatusError(w, r, http.StatusForbidden, "oidc_login_failed", "the oidc provider rejected the login", errors.New(e))
+		return
+	}
+	if !ok || r.FormValue("state") != st.State {
+		writeError(w, r, errInvalidOIDCState)
+		return
+	}
+
+	rawIDToken, err := a.oidc.Exchange(r.Context(), r.FormValue("code"), st.Verifier)
+	if err != nil {
+		log.Warnf("oidc: code exchange failed from %s: %s", r.RemoteAddr, err)
+		writeStatusError(w, r, http.StatusForbidden, "oidc_login_failed", "oidc login failed", err)
+		return
+	}
+	claims, err := a.oidc.Verify(r.Context(), rawIDToken, st.Nonce)
+	if err != nil {
+		log.Warnf("oidc: invalid id_token from %s: %s", r.RemoteAddr, err)
+		writeStatusError(w, r, http.StatusForbidden, "oidc_login_failed", "oidc login failed", err)
+		return
+	}
+	id, err := a.oidc.Identity(claims)
+	if err != nil {
+		writeStatusError(w, r, http.StatusForbidden, "oidc_login_failed", "oidc login failed", err)
+		return
+	}
+
+	ip := remoteIP(r)
+	if err := a.manager.CheckLogin(id.Username, ip); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// two-factor authentication is left to the provider
+	if _, err := a.provisionAccount(id.Username, a.oidc.Name(), id.Roles, a.oidc.AutocreateUsers, a.oidc.RolesClaim != ""); err != nil {
+		if err == manager.ErrAccountDoesNotExist || err == manager.ErrAccountProviderMismatch {
+			a.manager.RecordLogin(id.Username, ip, false)
+			log.Warnf("oidc: no account for %s: %s", id.Username, err)
+			writeStatusError(w, r, http.StatusForbidden, "no_account", "no account for this user", err)
+			return
+		}
+		a.manager.ReleaseLogin(id.Username, ip)
+		log.Errorf("oidc: error provisioning account %s: %s", id.Username, err)
+		writeError(w, r, err)
+		return
+	}
+	a.manager.RecordLogin(id.Username, ip, true)
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		token, err := a.newAuthToken(id.Username, r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, token)
+		return
+	}
+
+	// browsers are logged in with a session cookie and sent back to the
+	// ui; no credential goes into the url, where it would stay in the
+	// browser history
+	if _, err := a.manager.NewSession(w, r, id.Username); err != nil {
+		log.Errorf("oidc: error creating session for %s: %s", id.Username, err)
+		writeError(w, r, err)
+		return
+	}
+	v := url.Values{}
+	v.Set("username", id.Username)
+	if acct, err := a.manager.Account(id.Username); err == nil && a.manager.TOTPRequired(acct) {
+		v.Set("totp_enrollment_required", "true")
+	}
+	http.Redirect(w, r, a.oidc.PostLoginURL+"#/oidc?"+v.Encode(), http.StatusFound)
+}
@@ -0,0 +1,559 @@
+package api
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nicle-lin/lillian/controller/manager"
+	"github.com/nicle-lin/lillian/controller/response"
+	"github.com/nicle-lin/lillian/helper/auth"
+	"github.com/nicle-lin/lillian/helper/auth/jwt"
+	"github.com/nicle-lin/lillian/model"
+	"github.com/nicle-lin/lillian/version"
+)
+
+// spec documents a route in the openapi document. Routes without a spec
+// are a test failure, so the document can not fall behind the router.
+type spec struct {
+	summary string
+	tag     string
+	query   []param
+	// request and response are the json bodies; without a response the
+	// route answers 204 unless status says otherwise
+	request  interface{}
+	response interface{}
+	status   int
+	// contentType of the response when it is not json
+	contentType string
+	// redirect describes a 302 response
+	redirect string
+	errors   []int
+}
+
+type param struct {
+	name        string
+	description string
+	typ         string
+}
+
+var exportParam = param{"format", "json (default), csv or xlsx; other formats are downloaded as a file", "string"}
+
+// specs are keyed by method and path relative to the api prefix; prefix
+// routes use * as method
+var specs = map[string]spec{
+	"POST /account/changepassword": {
+		summary: "Change the password of the current user", tag: "account",
+		request: passwordChange{}, status: http.StatusOK, errors: []int{400, 403, 429},
+	},
+	"POST /account/totp": {
+		summary: "Start two-factor enrolment and get the secret", tag: "account",
+		response: totpEnrollment{}, errors: []int{409},
+	},
+	"POST /account/totp/verify": {
+		summary: "Enable two-factor authentication with a first code", tag: "account",
+		request: totpCode{}, response: totpRecoveryCodes{}, errors: []int{400, 409},
+	},
+	"DELETE /account/totp": {
+		summary: "Disable two-factor authentication with a code or recovery code", tag: "account",
+		request: totpCode{}, errors: []int{400, 409},
+	},
+	"GET /account/sessions": {
+		summary: "List the browser sessions of the current user", tag: "account",
+		response: []manager.Session{}, errors: []int{404},
+	},
+	"DELETE /account/sessions/{id}": {
+		summary: "End a browser session of the current user", tag: "account",
+		errors: []int{404},
+	},
+	"GET /accounts": {
+		summary: "List accounts", tag: "accounts",
+		query: []param{exportParam}, response: []auth.Account{}, errors: []int{400},
+	},
+	"POST /accounts": {
+		summary: "Create or update an account", tag: "accounts",
+		request: auth.Account{}, errors: []int{400},
+	},
+	"GET /accounts/{username}": {
+		summary: "Get an account", tag: "accounts",
+		response: auth.Account{}, errors: []int{404},
+	},
+	"DELETE /accounts/{username}": {
+		summary: "Delete an account", tag: "accounts",
+		errors: []int{404},
+	},
+	"DELETE /accounts/{username}/totp": {
+		summary: "Reset two-factor authentication of a user", tag: "accounts",
+		errors: []int{404},
+	},
+	"GET /accounts/{username}/sessions": {
+		summary: "List the browser sessions of a user", tag: "accounts",
+		response: []manager.Session{}, errors: []int{404},
+	},
+	"DELETE /accounts/{username}/sessions": {
+		summary: "Log a user out of every session and revoke their refresh tokens", tag: "accounts",
+		errors: []int{404},
+	},
+	"DELETE /accounts/{username}/sessions/{id}": {
+		summary: "End a browser session of a user", tag: "accounts",
+		errors: []int{404},
+	},
+	"GET /events": {
+		summary: "List events, newest first", tag: "events",
+		query: []param{
+			{"limit", "maximum number of events, 100 by default and at most 10000", "integer"},
+			{"type", "only return events of this type", "string"},
+			exportParam,
+		},
+		response: []model.Event{}, errors: []int{400},
+	},
+	"DELETE /events": {
+		summary: "Delete all events", tag: "events",
+	},
+	"GET /extensions": {
+		summary: "List extensions", tag: "extensions",
+		response: []model.Extension{},
+	},
+	"POST /extensions": {
+		summary: "Register an extension", tag: "extensions",
+		request: model.Extension{}, response: savedExtension{}, errors: []int{400},
+	},
+	"GET /extensions/{name}": {
+		summary: "Get an extension", tag: "extensions",
+		response: model.Extension{}, errors: []int{404},
+	},
+	"DELETE /extensions/{name}": {
+		summary: "Delete an extension", tag: "extensions",
+		errors: []int{404},
+	},
+	"* /extensions/{name}/": {
+		summary: "Proxy a request to an extension", tag: "extensions",
+		status: http.StatusOK, errors: []int{404, 502},
+	},
+	"POST /login": {
+		summary: "Log in and get api tokens", tag: "auth",
+		request: Credentials{}, response: auth.AuthToken{}, errors: []int{400, 401, 403, 429},
+	},
+	"GET /auth/oidc/login": {
+		summary: "Start a login at the OpenID Connect provider", tag: "auth",
+		redirect: "redirect to the provider", errors: []int{404, 502},
+	},
+	"GET /auth/oidc/callback": {
+		summary: "Finish an OpenID Connect login", tag: "auth",
+		query: []param{
+			{"code", "authorization code from the provider", "string"},
+			{"state", "state of the login", "string"},
+		},
+		response: auth.AuthToken{}, redirect: "redirect to the ui with a session cookie, unless json is accepted",
+		errors: []int{400, 403, 404, 429},
+	},
+	"POST /auth/session": {
+		summary: "Log the browser in with a session cookie", tag: "auth",
+		request: Credentials{}, response: sessionInfo{}, errors: []int{400, 401, 403, 404, 415, 429},
+	},
+	"DELETE /auth/session": {
+		summary: "Log the browser out", tag: "auth",
+		errors: []int{403, 404},
+	},
+	"POST /auth/token/refresh": {
+		summary: "Trade a refresh token for new tokens", tag: "auth",
+		request: refreshRequest{}, response: auth.AuthToken{}, errors: []int{400, 401, 404},
+	},
+	"GET /auth/jwks.json": {
+		summary: "Public keys access tokens are signed with", tag: "auth",
+		response: jwt.JWKS{}, contentType: "application/jwk-set+json", errors: []int{404},
+	},
+	"GET /openapi.json": {
+		summary: "This document", tag: "docs",
+		status: http.StatusOK,
+	},
+	"GET /docs": {
+		summary: "Api documentation", tag: "docs",
+		status: http.StatusOK, contentType: "text/html",
+		errors: []int{http.StatusNotFound},
+	},
+}
+
+func specKey(rt route) string {
+	if rt.prefix {
+		return "* " + rt.path
+	}
+	return rt.method + " " + rt.path
+}
+
+type (
+	openAPIDocument struct {
+		OpenAPI    string                           `json:"openapi"`
+		Info       openAPIInfo                      `json:"info"`
+		Servers    []openAPIServer                  `json:"servers"`
+		Security   []securityRequirement            `json:"security"`
+		Paths      map[string]map[string]*operation `json:"paths"`
+		Components components                       `json:"components"`
+	}
+
+	openAPIInfo struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Version     string `json:"version"`
+	}
+
+	openAPIServer struct {
+		URL         string `json:"url"`
+		Description string `json:"description,omitempty"`
+	}
+
+	securityRequirement map[string][]string
+
+	operation struct {
+		Summary     string                  `json:"summary"`
+		OperationID string                  `json:"operationId"`
+		Tags        []string                `json:"tags,omitempty"`
+		Parameters  []parameter             `json:"parameters,omitempty"`
+		RequestBody *requestBody            `json:"requestBody,omitempty"`
+		Responses   map[string]*apiResponse `json:"responses"`
+		// public operations override the document security with []
+		Security *[]securityRequirement `json:"security,omitempty"`
+	}
+
+	parameter struct {
+		Name        string  `json:"name"`
+		In          string  `json:"in"`
+		Description string  `json:"description,omitempty"`
+		Required    bool    `json:"required,omitempty"`
+		Schema      *schema `json:"schema"`
+	}
+
+	requestBody struct {
+		Required bool                 `json:"required"`
+		Content  map[string]mediaType `json:"content"`
+	}
+
+	apiResponse struct {
+		Description string               `json:"description"`
+		Content     map[string]mediaType `json:"content,omitempty"`
+	}
+
+	mediaType struct {
+		Schema *schema `json:"schema,omitempty"`
+	}
+
+	components struct {
+		Schemas         schemas                   `json:"schemas"`
+		SecuritySchemes map[string]securityScheme `json:"securitySchemes"`
+	}
+
+	securityScheme struct {
+		Type         string `json:"type"`
+		Description  string `json:"description,omitempty"`
+		Name         string `json:"name,omitempty"`
+		In           string `json:"in,omitempty"`
+		Scheme       string `json:"scheme,omitempty"`
+		BearerFormat string `json:"bearerFormat,omitempty"`
+	}
+
+	schema struct {
+		Ref                  string             `json:"$ref,omitempty"`
+		Type                 string             `json:"type,omitempty"`
+		Format               string             `json:"format,omitempty"`
+		Properties           map[string]*schema `json:"properties,omitempty"`
+		Required             []string           `json:"required,omitempty"`
+		Items                *schema            `json:"items,omitempty"`
+		AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+	}
+
+	// schemas are the named models, built from the go types
+	schemas map[string]*schema
+)
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	pathParams = regexp.MustCompile(`\{([^}]+)\}`)
+
+	securitySchemes = map[string]securityScheme{
+		"authToken": {Type: "apiKey", In: "header", Name: "X-Access-Token",
+			Description: "`<username>:<auth_token>` as returned by the login"},
+		"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT",
+			Description: "access_token returned by the login when jwt is enabled"},
+		"serviceKey": {Type: "apiKey", In: "header", Name: "X-Service-Key",
+			Description: "service key of an extension or integration"},
+		"session": {Type: "apiKey", In: "cookie", Name: "lilliansessionid",
+			Description: "browser session; requests that change something also need the XSRF-TOKEN cookie in the X-XSRF-TOKEN header. The cookie name is configurable."},
+	}
+)
+
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// of returns the schema of t; structs are added to the named models and
+// referenced
+func (s schemas) of(t reflect.Type) *schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.of(t.Elem())
+	case reflect.Struct:
+		if t == timeType {
+			return &schema{Type: "string", Format: "date-time"}
+		}
+		name := schemaName(t)
+		if _, ok := s[name]; !ok {
+			obj := &schema{Type: "object", Properties: map[string]*schema{}}
+			s[name] = obj
+			s.addFields(obj, t)
+		}
+		return &schema{Ref: "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &schema{Type: "string", Format: "byte"}
+		}
+		return &schema{Type: "array", Items: s.of(t.Elem())}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: s.of(t.Elem())}
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int64, reflect.Uint64:
+		return &schema{Type: "integer", Format: "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number"}
+	}
+	return &schema{}
+}
+
+// addFields adds the json fields of the struct t to obj; embedded
+// structs without a json name are flattened like encoding/json does
+func (s schemas) addFields(obj *schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && tag[0] == "" && ft.Kind() == reflect.Struct {
+			s.addFields(obj, ft)
+			continue
+		}
+		if f.PkgPath != "" || tag[0] == "-" {
+			continue
+		}
+		field := tag[0]
+		if field == "" {
+			field = f.Name
+		}
+		obj.Properties[field] = s.of(f.Type)
+		if len(tag) < 2 || tag[1] != "omitempty" {
+			obj.Required = append(obj.Required, field)
+		}
+	}
+}
+
+func jsonContent(contentType string, sch *schema) map[string]mediaType {
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	return map[string]mediaType{contentType: {Schema: sch}}
+}
+
+// newOperation documents a route. Authenticated routes can always fail
+// with 401 and 403, every route with 500.
+func (s schemas) newOperation(rt route, sp spec, method string, public bool) *operation {
+	path := strings.TrimSuffix(rt.path, "/")
+	op := &operation{
+		Summary:     sp.summary,
+		OperationID: operationID(method, path),
+		Responses:   map[string]*apiResponse{},
+	}
+	if sp.tag != "" {
+		op.Tags = []string{sp.tag}
+	}
+	if public {
+		op.Security = &[]securityRequirement{}
+	}
+
+	for _, m := range pathParams.FindAllStringSubmatch(rt.path, -1) {
+		op.Parameters = append(op.Parameters, parameter{Name: m[1], In: "path", Required: true, Schema: &schema{Type: "string"}})
+	}
+	if rt.prefix {
+		op.Parameters = append(op.Parameters, parameter{Name: "path", In: "path", Required: true,
+			Description: "rest of the path, forwarded to the extension", Schema: &schema{Type: "string"}})
+	}
+	for _, q := range sp.query {
+		op.Parameters = append(op.Parameters, parameter{Name: q.name, In: "query", Description: q.description, Schema: &schema{Type: q.typ}})
+	}
+
+	if sp.request != nil {
+		op.RequestBody = &requestBody{Required: true, Content: jsonContent("", s.of(reflect.TypeOf(sp.request)))}
+	}
+
+	status := sp.status
+	switch {
+	case status == 0 && sp.response == nil:
+		status = http.StatusNoContent
+	case status == 0:
+		status = http.StatusOK
+	}
+	res := &apiResponse{Description: http.StatusText(status)}
+	if sp.response != nil {
+		res.Content = jsonContent(sp.contentType, s.of(reflect.TypeOf(sp.response)))
+	} else if sp.contentType != "" {
+		res.Content = jsonContent(sp.contentType, &schema{Type: "string"})
+	}
+	op.Responses[fmt.Sprint(status)] = res
+	if sp.redirect != "" {
+		op.Responses["302"] = &apiResponse{Description: sp.redirect}
+	}
+
+	errors := sp.errors
+	if !public {
+		errors = append(errors, http.StatusUnauthorized, http.StatusForbidden)
+	}
+	errors = append(errors, http.StatusInternalServerError)
+	for _, code := range errors {
+		op.Responses[fmt.Sprint(code)] = &apiResponse{
+			Description: http.StatusText(code),
+			Content:     jsonContent("", &schema{Ref: "#/components/schemas/ErrorResponse"}),
+		}
+	}
+	return op
+}
+
+// operationID turns e.g. GET /accounts/{username}/sessions into
+// getAccountsUsernameSessions
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	}) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// openAPI builds the document from the route table
+func (a *Api) openAPI() (*openAPIDocument, error) {
+	doc := &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title: "lillian api",
+			Description: "Errors are returned in an envelope with a stable code. Every response carries an " +
+				"X-Request-ID header. The routes are also served under /api for existing clients.",
+			Version: version.Version,
+		},
+		Servers: []openAPIServer{{URL: apiV1Prefix}},
+		Security: []securityRequirement{
+			{"authToken": {}}, {"bearer": {}}, {"serviceKey": {}}, {"session": {}},
+		},
+		Paths: map[string]map[string]*operation{},
+		Components: components{
+			Schemas:         schemas{},
+			SecuritySchemes: securitySchemes,
+		},
+	}
+
+	s := doc.Components.Schemas
+	s.of(reflect.TypeOf(response.Error{}))
+	s["ErrorResponse"] = &schema{
+		Type:       "object",
+		Properties: map[string]*schema{"error": {Ref: "#/components/schemas/Error"}},
+		Required:   []string{"error"},
+	}
+
+	add := func(routes []route, public bool) error {
+		for _, rt := range routes {
+			sp, ok := specs[specKey(rt)]
+			if !ok {
+				return fmt.Errorf("没有 openapi 描述: %s", specKey(rt))
+			}
+			path := rt.path
+			methods := []string{rt.method}
+			if rt.prefix {
+				path += "{path}"
+				methods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+			}
+			if doc.Paths[path] == nil {
+				doc.Paths[path] = map[string]*operation{}
+			}
+			for _, m := range methods {
+				doc.Paths[path][strings.ToLower(m)] = s.newOperation(rt, sp, m, public)
+			}
+		}
+		return nil
+	}
+	if err := add(a.routes(), false); err != nil {
+		return nil, err
+	}
+	if err := add(a.publicRoutes(), true); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (a *Api) openAPISpec(w http.ResponseWriter, r *http.Request) {
+	doc, err := a.openAPI()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, doc)
+}
+
+// swaggerUIDir is where the frontend build puts the swagger-ui.css and
+// swagger-ui-bundle.js of its pinned swagger-ui-dist package, so the docs
+// page loads them from the binary instead of a cdn
+const swaggerUIDir = "swagger-ui"
+
+// docsPage renders openapi.json next to it with swagger ui
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>lillian api</title>
+  <link rel="stylesheet" href="/` + swaggerUIDir + `/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="/` + swaggerUIDir + `/swagger-ui-bundle.js"></script>
+  <script>
+    SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
+
+// docsMissingPage is shown when the frontend was built without swagger ui
+const docsMissingPage = `<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>lillian api</title>
+</head>
+<body>
+<p>前端构建中没有 Swagger UI. 请把 swagger-ui-dist 的 swagger-ui.css 和 swagger-ui-bundle.js 复制到前端的 ` + swaggerUIDir + `/ 目录, 或者直接使用 <a href="openapi.json">openapi.json</a>.</p>
+</body>
+</html>
+`
+
+func bundled(fsys fs.FS, name string) bool {
+	_, err := fs.Stat(fsys, name)
+	return err == nil
+}
+
+func (a *Api) docs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	if a.frontend == nil || !bundled(a.frontend, swaggerUIDir+"/swagger-ui-bundle.js") {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(docsMissingPage))
+		return
+	}
+	w.Write([]byte(docsPage))
+}
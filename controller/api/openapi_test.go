@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEveryRouteHasSpec(t *testing.T) {
+	a := &Api{}
+	routes := append(a.routes(), a.publicRoutes()...)
+
+	documented := map[string]bool{}
+	for _, rt := range routes {
+		key := specKey(rt)
+		if _, ok := specs[key]; !ok {
+			t.Fatalf("expected an openapi spec for %s; add it to specs in openapi.go", key)
+		}
+		documented[key] = true
+	}
+	for key := range specs {
+		if !documented[key] {
+			t.Fatalf("expected a route for spec %s", key)
+		}
+	}
+
+	if _, err := a.openAPI(); err != nil {
+		t.Fatalf("error building openapi document: %s", err)
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	a := &Api{}
+	req, _ := http.NewRequest("GET", "/api/openapi.json", nil)
+	res := httptest.NewRecorder()
+	a.openAPISpec(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected 200; got %d", res.Code)
+	}
+
+	var doc struct {
+		OpenAPI    string                                       `json:"openapi"`
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas         map[string]interface{} `json:"schemas"`
+			SecuritySchemes map[string]interface{} `json:"securitySchemes"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.0.3" {
+		t.Fatalf("expected openapi 3.0.3; got %q", doc.OpenAPI)
+	}
+
+	op, ok := doc.Paths["/accounts/{username}"]["get"]
+	if !ok {
+		t.Fatal("expected GET /accounts/{username}")
+	}
+	responses := op["responses"].(map[string]interface{})
+	for _, code := range []string{"200", "401", "404", "500"} {
+		if _, ok := responses[code]; !ok {
+			t.Fatalf("expected %s response for GET /accounts/{username}", code)
+		}
+	}
+
+	login := doc.Paths["/login"]["post"]
+	if sec, ok := login["security"].([]interface{}); !ok || len(sec) != 0 {
+		t.Fatalf("expected login to need no authentication; got %v", login["security"])
+	}
+
+	for _, name := range []string{"Account", "AuthToken", "Event", "Extension", "Session", "ErrorResponse", "Error"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Fatalf("expected schema %s", name)
+		}
+	}
+	for _, name := range []string{"authToken", "bearer", "serviceKey", "session"} {
+		if _, ok := doc.Components.SecuritySchemes[name]; !ok {
+			t.Fatalf("expected security scheme %s", name)
+		}
+	}
+}
+
+func TestOperationID(t *testing.T) {
+	if id := operationID("GET", "/accounts/{username}/sessions"); id != "getAccountsUsernameSessions" {
+		t.Fatalf("expected getAccountsUsernameSessions; got %s", id)
+	}
+	if id := operationID("POST", "/auth/token/refresh"); id != "postAuthTokenRefresh" {
+		t.Fatalf("expected postAuthTokenRefresh; got %s", id)
+	}
+}
+
+func TestDocsUseBundledSwaggerUI(t *testing.T) {
+	a := &Api{frontend: fstest.MapFS{}}
+	res := httptest.NewRecorder()
+	a.docs(res, httptest.NewRequest("GET", "/api/docs", nil))
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without swagger ui in the frontend; received %d", res.Code)
+	}
+
+	a.frontend = fstest.MapFS{"swagger-ui/swagger-ui-bundle.js": {Data: []byte("//")}}
+	res = httptest.NewRecorder()
+	a.docs(res, httptest.NewRequest("GET", "/api/docs", nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected 200; received %d", res.Code)
+	}
+	body := res.Body.String()
+	if !strings.Contains(body, `src="/swagger-ui/swagger-ui-bundle.js"`) {
+		t.Fatalf("expected the bundled swagger ui script:\n%s", body)
+	}
+	if strings.Contains(body, "https://") {
+		t.Fatalf("expected no external assets:\n%s", body)
+	}
+}
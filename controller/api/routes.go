@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	apiV1Prefix = "/api/v1"
+	// apiPrefix serves the same routes unversioned for existing clients
+	apiPrefix = "/api"
+)
+
+// route is an api endpoint relative to the api prefix. Prefix routes
+// match everything below the path, with any method.
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+	prefix  bool
+}
+
+// routes need an authenticated user
+func (a *Api) routes() []route {
+	return []route{
+		{method: "POST", path: "/account/changepassword", handler: a.changePassword},
+		{method: "POST", path: "/account/totp", handler: a.enrollTOTP},
+		{method: "POST", path: "/account/totp/verify", handler: a.enableTOTP},
+		{method: "DELETE", path: "/account/totp", handler: a.disableTOTP},
+		{method: "GET", path: "/account/sessions", handler: a.accountSessions},
+		{method: "DELETE", path: "/account/sessions/{id}", handler: a.deleteAccountSession},
+		{method: "GET", path: "/accounts", handler: a.accounts},
+		{method: "POST", path: "/accounts", handler: a.saveAccount},
+		{method: "GET", path: "/accounts/{username}", handler: a.account},
+		{method: "DELETE", path: "/accounts/{username}", handler: a.deleteAccount},
+		{method: "DELETE", path: "/accounts/{username}/totp", handler: a.resetTOTP},
+		{method: "GET", path: "/accounts/{username}/sessions", handler: a.userSessions},
+		{method: "DELETE", path: "/accounts/{username}/sessions", handler: a.logoutUser},
+		{method: "DELETE", path: "/accounts/{username}/sessions/{id}", handler: a.deleteUserSession},
+		{method: "GET", path: "/events", handler: a.events},
+		{method: "DELETE", path: "/events", handler: a.purgeEvents},
+		{method: "GET", path: "/extensions", handler: a.extensions},
+		{method: "POST", path: "/extensions", handler: a.saveExtension},
+		{method: "GET", path: "/extensions/{name}", handler: a.extension},
+		{method: "DELETE", path: "/extensions/{name}", handler: a.deleteExtension},
+		{path: "/extensions/{name}/", handler: a.proxyExtension, prefix: true},
+	}
+}
+
+// publicRoutes are served without authentication
+func (a *Api) publicRoutes() []route {
+	return []route{
+		{method: "POST", path: "/login", handler: a.login},
+		{method: "GET", path: "/auth/oidc/login", handler: a.oidcLogin},
+		{method: "GET", path: "/auth/oidc/callback", handler: a.oidcCallback},
+		{method: "POST", path: "/auth/session", handler: a.sessionLogin},
+		{method: "DELETE", path: "/auth/session", handler: a.sessionLogout},
+		{method: "POST", path: "/auth/token/refresh", handler: a.refreshToken},
+		{method: "GET", path: "/auth/jwks.json", handler: a.jwks},
+		{method: "GET", path: "/openapi.json", handler: a.openAPISpec},
+		{method: "GET", path: "/docs", handler: a.docs},
+	}
+}
+
+// registerRoutes adds the routes under /api/v1 and the legacy /api
+func registerRoutes(router *mux.Router, routes []route) {
+	for _, p := range []string{apiV1Prefix, apiPrefix} {
+		for _, rt := range routes {
+			if rt.prefix {
+				router.PathPrefix(p + rt.path).HandlerFunc(rt.handler)
+				continue
+			}
+			router.HandleFunc(p+rt.path, rt.handler).Methods(rt.method)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"mime"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/nicle-lin/lillian/controller/manager"
+	"github.com/nicle-lin/lillian/controller/response"
+)
+
+// sessionInfo is returned by the browser login. The csrf token is also
+// set in the XSRF-TOKEN cookie and has to be sent in the X-XSRF-TOKEN
+// header of every request that changes something.
+type sessionInfo struct {
+	Username               string `json:"username"`
+	CSRFToken              string `json:"csrf_token"`
+	TOTPEnrollmentRequired bool   `json:"totp_enrollment_required,omitempty"`
+	PasswordChangeRequired bool   `json:"password_change_required,omitempty"`
+}
+
+// sessionLogin logs the browser ui in with a session cookie
+func (a *Api) sessionLogin(w http.ResponseWriter, r *http.Request) {
+	// a cross site form can post text/plain but not application/json, so
+	// this keeps other sites from logging the browser in
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/json" {
+		writeError(w, r, errJSONRequired)
+		return
+	}
+
+	username, ok := a.authenticateLogin(w, r)
+	if !ok {
+		return
+	}
+
+	s, err := a.manager.NewSession(w, r, username)
+	if err != nil {
+		log.Errorf("error creating session for %s: %s", username, err)
+		writeError(w, r, err)
+		return
+	}
+
+	info := sessionInfo{
+		Username:  username,
+		CSRFToken: s.CSRFToken,
+	}
+	if acct, err := a.manager.Account(username); err == nil {
+		info.TOTPEnrollmentRequired = a.manager.TOTPRequired(acct)
+		info.PasswordChangeRequired = acct.PasswordChangeRequired
+	}
+
+	writeJSON(w, info)
+}
+
+// sessionLogout ends the browser session. It is served without the auth
+// middleware, so it checks the csrf token itself.
+func (a *Api) sessionLogout(w http.ResponseWriter, r *http.Request) {
+	s, err := a.manager.CurrentSession(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !s.CheckCSRF(r) {
+		writeError(w, r, manager.ErrInvalidCSRFToken)
+		return
+	}
+
+	if err := a.manager.DestroySession(w, r); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Api) writeSessions(w http.ResponseWriter, r *http.Request, username string) {
+	sessions, err := a.manager.Sessions(username)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if current, err := a.manager.CurrentSession(r); err == nil {
+		for _, s := range sessions {
+			s.Current = s.ID == current.ID
+		}
+	}
+
+	writeJSON(w, sessions)
+}
+
+func (a *Api) accountSessions(w http.ResponseWriter, r *http.Request) {
+	username := currentUsername(r)
+	if username == "" {
+		response.Unauthorized(w, r)
+		return
+	}
+	a.writeSessions(w, r, username)
+}
+
+func (a *Api) deleteAccountSession(w http.ResponseWriter, r *http.Request) {
+	username := currentUsername(r)
+	if username == "" {
+		response.Unauthorized(w, r)
+		return
+	}
+	if err := a.manager.DeleteSession(username, mux.Vars(r)["id"]); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userSessions, deleteUserSession and logoutUser manage the sessions of
+// other users; /accounts is only open to the admin role
+func (a *Api) userSessions(w http.ResponseWriter, r *http.Request) {
+	a.writeSessions(w, r, mux.Vars(r)["username"])
+}
+
+func (a *Api) deleteUserSession(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := a.manager.DeleteSession(vars["username"], vars["id"]); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// logoutUser forces the user out of every browser session and revokes
+// their refresh tokens
+func (a *Api) logoutUser(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+	if _, err := a.manager.Account(username); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := a.manager.DeleteSessions(username); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/nicle-lin/lillian/controller/response"
+)
+
+type totpCode struct {
+	Code string `json:"code,omitempty"`
+}
+
+type totpEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type totpRecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (a *Api) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	username := currentUsername(r)
+	if username == "" {
+		response.Unauthorized(w, r)
+		return
+	}
+
+	secret, uri, err := a.manager.EnrollTOTP(username)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, &totpEnrollment{Secret: secret, URI: uri})
+}
+
+func (a *Api) enableTOTP(w http.ResponseWriter, r *http.Request) {
+	username := currentUsername(r)
+	if username == "" {
+		response.Unauthorized(w, r)
+		return
+	}
+
+	var req totpCode
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBadRequest(w, r, err)
+		return
+	}
+
+	codes, err := a.manager.EnableTOTP(username, req.Code)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Infof("enabled two-factor authentication: username=%s", username)
+
+	writeJSON(w, &totpRecoveryCodes{RecoveryCodes: codes})
+}
+
+// disableTOTP lets users turn off their own two-factor authentication
+// with a current code or a recovery code
+func (a *Api) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	username := currentUsername(r)
+	if username == "" {
+		response.Unauthorized(w, r)
+		return
+	}
+
+	var req totpCode
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBadRequest(w, r, err)
+		return
+	}
+
+	if err := a.manager.VerifyTOTP(username, req.Code); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := a.manager.DisableTOTP(username); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Infof("disabled two-factor authentication: username=%s", username)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resetTOTP lets admins turn off two-factor authentication for users
+// that lost their device and their recovery codes. /accounts is only
+// open to the admin role, which the access middleware enforces.
+func (a *Api) resetTOTP(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+
+	if err := a.manager.DisableTOTP(username); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Infof("reset two-factor authentication: username=%s by=%s", username, currentUsername(r))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,254 @@
+package manager
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nicle-lin/lillian/helper/auth"
+)
+
+const accountColumns = "id, username, first_name, last_name, password, roles, provider, totp_secret, totp_enabled, recovery_codes, password_change_required"
+
+func scanAccount(row interface {
+	Scan(dest ...interface{}) error
+}) (*auth.Account, error) {
+	var (
+		acct          auth.Account
+		roles         string
+		recoveryCodes string
+	)
+	if err := row.Scan(&acct.ID, &acct.Username, &acct.FirstName, &acct.LastName, &acct.Password, &roles, &acct.Provider,
+		&acct.TOTPSecret, &acct.TOTPEnabled, &recoveryCodes, &acct.PasswordChangeRequired); err != nil {
+		return nil, err
+	}
+	if roles != "" {
+		if err := json.Unmarshal([]byte(roles), &acct.Roles); err != nil {
+			return nil, err
+		}
+	}
+	if recoveryCodes != "" {
+		if err := json.Unmarshal([]byte(recoveryCodes), &acct.RecoveryCodes); err != nil {
+			return nil, err
+		}
+	}
+	return &acct, nil
+}
+
+func (m DefaultManager) Accounts() ([]*auth.Account, error) {
+	rows, err := m.mysql.Query(fmt.Sprintf("SELECT %s FROM %s ORDER BY username", accountColumns, tblNameAccounts))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*auth.Account{}
+	for rows.Next() {
+		acct, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, acct)
+	}
+	return accounts, rows.Err()
+}
+
+func (m DefaultManager) Account(username string) (*auth.Account, error) {
+	row := m.mysql.QueryRow(fmt.Sprintf("SELECT %s FROM %s WHERE username = ?", accountColumns, tblNameAccounts), username)
+	acct, err := scanAccount(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrAccountDoesNotExist
+	}
+	return acct, err
+}
+
+// SaveAccount creates the account or updates the existing account with
+// the same username. A non-empty password is hashed before it is stored;
+// an empty password keeps the current one.
+func (m DefaultManager) SaveAccount(account *auth.Account) error {
+	return m.saveAccount(account, false)
+}
+
+// CreateAccount creates the account and returns ErrAccountExists instead
+// of updating an account with the same username, also when it was created
+// concurrently
+func (m DefaultManager) CreateAccount(account *auth.Account) error {
+	return m.saveAccount(account, true)
+}
+
+func (m DefaultManager) saveAccount(account *auth.Account, create bool) error {
+	if account == nil || strings.TrimSpace(account.Username) == "" {
+		return ErrInvalidAccount
+	}
+
+	roles, err := json.Marshal(account.Roles)
+	if err != nil {
+		return err
+	}
+
+	existing, err := m.Account(account.Username)
+	if err != nil && err != ErrAccountDoesNotExist {
+		return err
+	}
+	if create && existing != nil {
+		return ErrAccountExists
+	}
+
+	// new accounts belong to builtin unless another provider is given,
+	// existing ones keep theirs
+	provider := account.Provider
+	if provider == "" {
+		provider = builtinProvider
+		if existing != nil {
+			provider = existing.Provider
+		}
+	}
+
+	hash := ""
+	if account.Password != "" {
+		if !m.passwordUpdatable(provider) {
+			return ErrPasswordChangeNotSupported
+		}
+		if err := m.checkNewPassword(account.Username, account.Password); err != nil {
+			return err
+		}
+		if hash, err = auth.Hash(account.Password); err != nil {
+			return err
+		}
+		// a local password binds unassigned accounts to builtin
+		if provider == "" {
+			provider = builtinProvider
+		}
+	}
+
+	// update
+	if existing != nil {
+		account.ID = existing.ID
+		if hash == "" {
+			hash = existing.Password
+		} else if err := m.recordPasswordHistory(account.Username, existing.Password); err != nil {
+			return err
+		} else if err := m.endSessions(account.Username); err != nil {
+			return err
+		}
+		if _, err := m.mysql.Exec(fmt.Sprintf("UPDATE %s SET first_name = ?, last_name = ?, password = ?, roles = ?, provider = ? WHERE id = ?",
+			tblNameAccounts), account.FirstName, account.LastName, hash, string(roles), provider, account.ID); err != nil {
+			return err
+		}
+		account.Provider = provider
+		m.LogEvent("update-account", fmt.Sprintf("username=%s roles=%s", account.Username, strings.Join(account.Roles, ",")),
+			[]string{"security"})
+		return nil
+	}
+
+	// new account
+	if account.ID, err = generateID(16); err != nil {
+		return err
+	}
+	if _, err := m.mysql.Exec(fmt.Sprintf("INSERT INTO %s (id, username, first_name, last_name, password, roles, provider, password_change_required) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		tblNameAccounts), account.ID, account.Username, account.FirstName, account.LastName, hash, string(roles), provider,
+		account.PasswordChangeRequired); err != nil {
+		// the username is unique, so a concurrent create fails here
+		if _, aerr := m.Account(account.Username); aerr == nil {
+			return ErrAccountExists
+		}
+		return err
+	}
+	account.Provider = provider
+	m.LogEvent("add-account", fmt.Sprintf("username=%s roles=%s", account.Username, strings.Join(account.Roles, ",")),
+		[]string{"security"})
+	return nil
+}
+
+func (m DefaultManager) DeleteAccount(account *auth.Account) error {
+	res, err := m.mysql.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = ?", tblNameAccounts), account.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrAccountDoesNotExist
+	}
+	for _, tbl := range []string{tblNameAuthTokens, tblNamePasswordHistory} {
+		if _, err := m.mysql.Exec(fmt.Sprintf("DELETE FROM %s WHERE username = ?", tbl), account.Username); err != nil {
+			return err
+		}
+	}
+	if err := m.endSessions(account.Username); err != nil {
+		return err
+	}
+	m.LogEvent("delete-account", fmt.Sprintf("username=%s", account.Username), []string{"security"})
+	return nil
+}
+
+func (m DefaultManager) ChangePassword(username, password string) error {
+	if password == "" {
+		return ErrInvalidAccount
+	}
+	existing, err := m.Account(username)
+	if err != nil {
+		return err
+	}
+	if !m.passwordUpdatable(existing.Provider) {
+		return ErrPasswordChangeNotSupported
+	}
+	if err := m.checkNewPassword(username, password); err != nil {
+		return err
+	}
+	hash, err := auth.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	if err := m.recordPasswordHistory(username, existing.Password); err != nil {
+		return err
+	}
+
+	provider := existing.Provider
+	if provider == "" {
+		provider = builtinProvider
+	}
+	res, err := m.mysql.Exec(fmt.Sprintf("UPDATE %s SET password = ?, provider = ?, password_change_required = 0 WHERE username = ?", tblNameAccounts),
+		hash, provider, username)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrAccountDoesNotExist
+	}
+	if err := m.endSessions(username); err != nil {
+		return err
+	}
+	m.LogEvent("change-password", fmt.Sprintf("username=%s", username), []string{"security"})
+	return nil
+}
+
+func (m DefaultManager) NewAuthToken(username string, userAgent string) (*auth.AuthToken, error) {
+	tk, err := m.authenticator.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := m.mysql.Exec(fmt.Sprintf("INSERT INTO %s (username, token, user_agent, created_at) VALUES (?, ?, ?, ?)",
+		tblNameAuthTokens), username, tk, userAgent, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return &auth.AuthToken{
+		Token:     tk,
+		UserAgent: userAgent,
+	}, nil
+}
+
+func (m DefaultManager) VerifyAuthToken(username, token string) error {
+	var n int
+	if err := m.mysql.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE username = ? AND token = ?", tblNameAuthTokens),
+		username, token).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidAuthToken
+	}
+	return nil
+}
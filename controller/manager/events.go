@@ -0,0 +1,82 @@
+package manager
+
+import (
+	"errors"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nicle-lin/lillian/helper/metrics"
+	"github.com/nicle-lin/lillian/model"
+)
+
+const eventQueueSize = 1024
+
+var (
+	eventWriteErrors = metrics.Default.NewCounterVec("lillian_event_write_errors_total",
+		"Events that could not be written to the database.", "type")
+	eventsDropped = metrics.Default.NewCounterVec("lillian_events_dropped_total",
+		"Events dropped because the event queue was full.", "type")
+
+	errEventQueueClosed = errors.New("manager closed")
+	errEventQueueFull   = errors.New("event queue full")
+)
+
+// eventQueue writes logged events in the background so requests never
+// wait on the database, and is drained when the manager is closed
+type eventQueue struct {
+	mu      sync.RWMutex
+	closed  bool
+	events  chan *model.Event
+	done    chan struct{}
+	pending sync.WaitGroup
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{
+		events: make(chan *model.Event, eventQueueSize),
+		done:   make(chan struct{}),
+	}
+}
+
+func (m DefaultManager) runEventQueue() {
+	defer close(m.queue.done)
+	for evt := range m.queue.events {
+		m.writeEvent(evt)
+	}
+}
+
+func (m DefaultManager) writeEvent(evt *model.Event) {
+	if err := m.SaveEvent(evt); err != nil {
+		eventWriteErrors.Inc(evt.Type)
+		log.Errorf("logging event error:%s\n", err)
+	}
+	m.notifyExtensions(evt)
+}
+
+// enqueue never blocks: it fails once the queue is closed and drops the
+// event when the queue is full, e.g. while the database is down. Waiting
+// for room while holding the lock would keep close from ever running.
+func (q *eventQueue) enqueue(evt *model.Event) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return errEventQueueClosed
+	}
+	select {
+	case q.events <- evt:
+		return nil
+	default:
+		eventsDropped.Inc(evt.Type)
+		return errEventQueueFull
+	}
+}
+
+// close stops accepting events; done is closed once the queue is drained
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.events)
+	}
+}
@@ -0,0 +1,62 @@
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nicle-lin/lillian/model"
+)
+
+func TestCloseFlushesEvents(t *testing.T) {
+	m, err := NewManager(nil, nil, nil, false, nil, SecurityConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		m.LogEvent("test", "queued", nil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(m.(*DefaultManager).queue.events); n != 0 {
+		t.Fatalf("expected drained queue; %d events left", n)
+	}
+
+	// logging after close must not panic
+	m.LogEvent("test", "dropped", nil)
+}
+
+func TestFullQueueDoesNotBlockClose(t *testing.T) {
+	// nothing drains this queue, like a database that stopped answering
+	q := newEventQueue()
+	for i := 0; i < eventQueueSize; i++ {
+		if err := q.enqueue(&model.Event{Type: "test"}); err != nil {
+			t.Fatalf("expected event %d to be queued: %s", i, err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		if err := q.enqueue(&model.Event{Type: "test"}); err != errEventQueueFull {
+			t.Errorf("expected errEventQueueFull; got %v", err)
+		}
+		q.close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected close not to block on a full queue")
+	}
+
+	if err := q.enqueue(&model.Event{Type: "test"}); err != errEventQueueClosed {
+		t.Fatalf("expected errEventQueueClosed; got %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package manager
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nicle-lin/lillian/model"
+)
+
+var extensionClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+func scanExtension(row interface {
+	Scan(dest ...interface{}) error
+}) (*model.Extension, error) {
+	var (
+		ext    model.Extension
+		events string
+		hooks  string
+	)
+	if err := row.Scan(&ext.ID, &ext.Name, &ext.Description, &ext.URL, &ext.ServiceKey, &events, &hooks); err != nil {
+		return nil, err
+	}
+	if events != "" {
+		if err := json.Unmarshal([]byte(events), &ext.Events); err != nil {
+			return nil, err
+		}
+	}
+	if hooks != "" {
+		if err := json.Unmarshal([]byte(hooks), &ext.Hooks); err != nil {
+			return nil, err
+		}
+	}
+	return &ext, nil
+}
+
+func (m DefaultManager) Extensions() ([]*model.Extension, error) {
+	rows, err := m.mysql.Query(fmt.Sprintf("SELECT id, name, description, url, service_key, events, hooks FROM %s ORDER BY name",
+		tblNameExtensions))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exts := []*model.Extension{}
+	for rows.Next() {
+		ext, err := scanExtension(rows)
+		if err != nil {
+			return nil, err
+		}
+		exts = append(exts, ext)
+	}
+	return exts, rows.Err()
+}
+
+func (m DefaultManager) Extension(name string) (*model.Extension, error) {
+	row := m.mysql.QueryRow(fmt.Sprintf("SELECT id, name, description, url, service_key, events, hooks FROM %s WHERE name = ?",
+		tblNameExtensions), name)
+	ext, err := scanExtension(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrExtensionDoesNotExist
+	}
+	return ext, err
+}
+
+func (m DefaultManager) SaveExtension(ext *model.Extension) error {
+	if ext.Name == "" || strings.ContainsAny(ext.Name, "/?#") {
+		return ErrInvalidExtension
+	}
+	if !strings.HasPrefix(ext.URL, "http://") && !strings.HasPrefix(ext.URL, "https://") {
+		return ErrInvalidExtension
+	}
+
+	events, err := json.Marshal(ext.Events)
+	if err != nil {
+		return err
+	}
+	hooks, err := json.Marshal(ext.Hooks)
+	if err != nil {
+		return err
+	}
+
+	existing, err := m.Extension(ext.Name)
+	if err != nil && err != ErrExtensionDoesNotExist {
+		return err
+	}
+
+	// update
+	if existing != nil {
+		ext.ID = existing.ID
+		if ext.ServiceKey == "" {
+			ext.ServiceKey = existing.ServiceKey
+		}
+		if _, err := m.mysql.Exec(fmt.Sprintf("UPDATE %s SET description = ?, url = ?, service_key = ?, events = ?, hooks = ? WHERE id = ?",
+			tblNameExtensions), ext.Description, ext.URL, ext.ServiceKey, string(events), string(hooks), ext.ID); err != nil {
+			return err
+		}
+		m.LogEvent("update-extension", fmt.Sprintf("name=%s url=%s", ext.Name, ext.URL), []string{"extensions"})
+		return nil
+	}
+
+	// new extension
+	if ext.ID, err = generateID(16); err != nil {
+		return err
+	}
+	if ext.ServiceKey == "" {
+		if ext.ServiceKey, err = generateID(32); err != nil {
+			return err
+		}
+	}
+	if _, err := m.mysql.Exec(fmt.Sprintf("INSERT INTO %s (id, name, description, url, service_key, events, hooks) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		tblNameExtensions), ext.ID, ext.Name, ext.Description, ext.URL, ext.ServiceKey, string(events), string(hooks)); err != nil {
+		return err
+	}
+	m.LogEvent("add-extension", fmt.Sprintf("name=%s url=%s", ext.Name, ext.URL), []string{"extensions"})
+	return nil
+}
+
+func (m DefaultManager) DeleteExtension(ext *model.Extension) error {
+	res, err := m.mysql.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = ?", tblNameExtensions), ext.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrExtensionDoesNotExist
+	}
+	m.LogEvent("delete-extension", fmt.Sprintf("name=%s", ext.Name), []string{"extensions"})
+	return nil
+}
+
+// notifyExtensions posts the event to every extension subscribed to its type.
+// Delivery is best effort: failures are logged but never turned into events
+// to avoid notification loops.
+func (m DefaultManager) notifyExtensions(evt *model.Event) {
+	if m.mysql == nil {
+		return
+	}
+	exts, err := m.Extensions()
+	if err != nil {
+		log.Errorf("error loading extensions for event %s: %s", evt.Type, err)
+		return
+	}
+
+	for _, ext := range exts {
+		if !ext.Subscribes(evt.Type) {
+			continue
+		}
+		m.queue.pending.Add(1)
+		go func(ext *model.Extension) {
+			defer m.queue.pending.Done()
+			body, err := json.Marshal(evt)
+			if err != nil {
+				log.Errorf("error encoding event for extension %s: %s", ext.Name, err)
+				return
+			}
+			req, err := http.NewRequest("POST", strings.TrimRight(ext.URL, "/")+"/events", bytes.NewReader(body))
+			if err != nil {
+				log.Errorf("error notifying extension %s: %s", ext.Name, err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Service-Key", ext.ServiceKey)
+			resp, err := extensionClient.Do(req)
+			if err != nil {
+				log.Warnf("error notifying extension %s: %s", ext.Name, err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				log.Warnf("extension %s rejected event %s: status=%d", ext.Name, evt.Type, resp.StatusCode)
+			}
+		}(ext)
+	}
+}
@@ -0,0 +1,137 @@
+package manager
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nicle-lin/mysql"
+)
+
+// fakeDB answers queries with the handler of the longest matching
+// prefix, so manager tests can run without a mysql server
+type fakeDB struct {
+	mu       sync.Mutex
+	handlers map[string]fakeHandler
+}
+
+// fakeHandler returns the columns and rows of a query, or the rows
+// affected by a statement
+type fakeHandler func(args []driver.Value) (columns []string, rows [][]driver.Value, affected int64, err error)
+
+var (
+	fakeDBsMu sync.Mutex
+	fakeDBs   = map[string]*fakeDB{}
+)
+
+func init() {
+	sql.Register("lillian-fake", fakeDriver{})
+}
+
+// newFakeMysql returns a store backed by a fakeDB with the handlers
+func newFakeMysql(t *testing.T, handlers map[string]fakeHandler) *mysql.Mysql {
+	fakeDBsMu.Lock()
+	fakeDBs[t.Name()] = &fakeDB{handlers: handlers}
+	fakeDBsMu.Unlock()
+
+	db, err := sql.Open("lillian-fake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakeDBsMu.Lock()
+		delete(fakeDBs, t.Name())
+		fakeDBsMu.Unlock()
+	})
+	return &mysql.Mysql{DB: db}
+}
+
+func (db *fakeDB) run(query string, args []driver.Value) ([]string, [][]driver.Value, int64, error) {
+	query = strings.Join(strings.Fields(query), " ")
+	match := ""
+	for prefix := range db.handlers {
+		if strings.HasPrefix(query, prefix) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+	if match == "" {
+		return nil, nil, 0, fmt.Errorf("fakedb: unexpected query %q", query)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.handlers[match](args)
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	db, ok := fakeDBs[name]
+	if !ok {
+		return nil, fmt.Errorf("fakedb: unknown database %s", name)
+	}
+	return &fakeConn{db: db}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	_, _, affected, err := s.db.run(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(affected), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	columns, rows, _, err := s.db.run(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: columns, rows: rows}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
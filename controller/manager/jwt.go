@@ -0,0 +1,117 @@
+package manager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nicle-lin/lillian/helper/auth"
+	"github.com/nicle-lin/lillian/helper/auth/jwt"
+)
+
+const tblNameRefreshTokens = "refresh_tokens"
+
+// NewJWT issues a short lived access token and a refresh token for the
+// user. Only the refresh token is stored, access tokens are checked by
+// their signature alone.
+func (m DefaultManager) NewJWT(username, userAgent string) (*auth.AuthToken, error) {
+	issuer := m.security.JWT
+	if issuer == nil {
+		return nil, ErrJWTDisabled
+	}
+
+	now := time.Now()
+	access, claims, err := issuer.NewAccessToken(username, now)
+	if err != nil {
+		return nil, err
+	}
+	refresh, refreshClaims, err := issuer.NewRefreshToken(username, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// forget the expired refresh tokens of the user on the way
+	if _, err := m.mysql.Exec(fmt.Sprintf("DELETE FROM %s WHERE username = ? AND expires_at < ?", tblNameRefreshTokens),
+		username, now); err != nil {
+		return nil, err
+	}
+	if _, err := m.mysql.Exec(fmt.Sprintf("INSERT INTO %s (id, username, user_agent, expires_at, created_at) VALUES (?, ?, ?, ?, ?)",
+		tblNameRefreshTokens), refreshClaims.ID, username, userAgent, time.Unix(refreshClaims.ExpiresAt, 0), now); err != nil {
+		return nil, err
+	}
+
+	return &auth.AuthToken{
+		UserAgent:    userAgent,
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    claims.ExpiresAt - now.Unix(),
+		RefreshToken: refresh,
+	}, nil
+}
+
+// RefreshJWT trades a refresh token for a new access and refresh token.
+// Refresh tokens can only be used once; presenting one that was already
+// used revokes all refresh tokens of the user, since it was most likely
+// stolen.
+func (m DefaultManager) RefreshJWT(refreshToken, userAgent string) (*auth.AuthToken, error) {
+	issuer := m.security.JWT
+	if issuer == nil {
+		return nil, ErrJWTDisabled
+	}
+
+	claims, err := issuer.Verify(refreshToken, jwt.TypeRefresh, time.Now())
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	res, err := m.mysql.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = ? AND username = ? AND expires_at > ?", tblNameRefreshTokens),
+		claims.ID, claims.Subject, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		if err := m.revokeRefreshTokens(claims.Subject); err != nil {
+			return nil, err
+		}
+		m.LogEvent("refresh-token-reuse", fmt.Sprintf("username=%s", claims.Subject), []string{"security"})
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if _, err := m.Account(claims.Subject); err != nil {
+		if err == ErrAccountDoesNotExist {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	return m.NewJWT(claims.Subject, userAgent)
+}
+
+// VerifyJWT checks an access token and returns the user it was issued to
+func (m DefaultManager) VerifyJWT(token string) (string, error) {
+	issuer := m.security.JWT
+	if issuer == nil {
+		return "", ErrJWTDisabled
+	}
+	claims, err := issuer.Verify(token, jwt.TypeAccess, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// JWKS returns the public keys access tokens can be verified with
+func (m DefaultManager) JWKS() (*jwt.JWKS, error) {
+	if m.security.JWT == nil {
+		return nil, ErrJWTDisabled
+	}
+	return m.security.JWT.Keys.JWKS(), nil
+}
+
+// revokeRefreshTokens logs the user out of every client once their
+// access tokens expire
+func (m DefaultManager) revokeRefreshTokens(username string) error {
+	if m.security.JWT == nil {
+		return nil
+	}
+	_, err := m.mysql.Exec(fmt.Sprintf("DELETE FROM %s WHERE username = ?", tblNameRefreshTokens), username)
+	return err
+}
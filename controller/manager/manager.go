@@ -1,11 +1,16 @@
 package manager
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/astaxie/beego/session"
 	"github.com/nicle-lin/lillian/helper/auth"
+	"github.com/nicle-lin/lillian/helper/auth/chain"
+	"github.com/nicle-lin/lillian/helper/auth/jwt"
 	"github.com/nicle-lin/lillian/model"
 	"github.com/nicle-lin/mysql"
 	"github.com/nicle-lin/redis"
@@ -20,6 +25,8 @@ const (
 	tblNameRoles       = "roles"
 	tblNameServiceKeys = "service_keys"
 	tblNameExtensions  = "extensions"
+	tblNameAuthTokens  = "auth_tokens"
+	builtinProvider    = "builtin"
 	storeKey           = "lillian"
 	trackerHost        = "http://1001ai.com"
 	NodeHealthUp       = "up"
@@ -28,16 +35,32 @@ const (
 
 var (
 	ErrLoginFailure               = errors.New("无效的用户名和密码")
+	ErrLoginLocked                = errors.New("登陆失败次数过多, 请稍后再试")
+	ErrPasswordChangeNotSupported = errors.New("该账户的密码由外部认证服务管理, 不能在这里修改")
+	ErrAccountProviderMismatch    = errors.New("该账户属于其他认证服务")
+	ErrOTPRequired                = errors.New("需要两步验证码")
+	ErrInvalidOTP                 = errors.New("无效的两步验证码")
+	ErrTOTPAlreadyEnabled         = errors.New("两步验证已启用")
+	ErrTOTPNotEnrolled            = errors.New("没有两步验证密钥, 请先注册")
+	ErrTOTPEnrollmentRequired     = errors.New("需要先启用两步验证")
+	ErrPasswordChangeRequired     = errors.New("需要先修改密码")
 	ErrAccountExists              = errors.New("账户已存在")
+	ErrInvalidAccount             = errors.New("无效的账户: 需要用户名和密码")
 	ErrAccountDoesNotExist        = errors.New("账户不存在")
 	ErrRoleDoesNotExist           = errors.New("角色不存在")
 	ErrNodeDoesNotExist           = errors.New("节点不存在")
 	ErrServiceKeyDoesNotExist     = errors.New("服务密钥不存在")
 	ErrInvalidAuthToken           = errors.New("无效的认证令牌")
+	ErrJWTDisabled                = errors.New("没有启用 JWT 令牌")
+	ErrInvalidRefreshToken        = errors.New("无效的刷新令牌")
 	ErrExtensionDoesNotExist      = errors.New("Extension 不存在")
+	ErrInvalidExtension           = errors.New("无效的 Extension: 需要名称和 http(s) 地址")
 	ErrWebhookKeyDoesNotExist     = errors.New("webhook key 不存在")
 	ErrRegistryDoesNotExist       = errors.New("registry 不存在")
 	ErrConsoleSessionDoesNotExist = errors.New("控制台session不存在")
+	ErrSessionsDisabled           = errors.New("没有配置 session")
+	ErrSessionDoesNotExist        = errors.New("session 不存在或已过期")
+	ErrInvalidCSRFToken           = errors.New("无效的 CSRF 令牌")
 )
 
 type DefaultManager struct {
@@ -47,6 +70,8 @@ type DefaultManager struct {
 	globalSessions   *session.Manager
 	mysql            *mysql.Mysql
 	redis            *redis.RedisPool
+	queue            *eventQueue
+	security         SecurityConfig
 }
 
 type ScaleResult struct {
@@ -61,21 +86,50 @@ type Manager interface {
 	Accounts() ([]*auth.Account, error)
 	Account(username string) (*auth.Account, error)
 	Authenticate(username, password string) (bool, error)
+	AuthenticateProvider(username, password string) (auth.Authenticator, error)
+	CheckLogin(username, ip string) error
+	RecordLogin(username, ip string, success bool)
+	ReleaseLogin(username, ip string)
+	EnrollTOTP(username string) (string, string, error)
+	EnableTOTP(username, code string) ([]string, error)
+	DisableTOTP(username string) error
+	VerifyTOTP(username, code string) error
+	TOTPRequired(acct *auth.Account) bool
 	GetAuthenticator() auth.Authenticator
 	SaveAccount(account *auth.Account) error
+	CreateAccount(account *auth.Account) error
 	DeleteAccount(account *auth.Account) error
 	NewAuthToken(username string, userAgent string) (*auth.AuthToken, error)
-	VerifyServiceKey(key string) error
+	VerifyServiceKey(key string) (string, error)
 	VerifyAuthToken(username, token string) error
+	NewJWT(username, userAgent string) (*auth.AuthToken, error)
+	RefreshJWT(refreshToken, userAgent string) (*auth.AuthToken, error)
+	VerifyJWT(token string) (string, error)
+	JWKS() (*jwt.JWKS, error)
+	NewSession(w http.ResponseWriter, r *http.Request, username string) (*Session, error)
+	CurrentSession(r *http.Request) (*Session, error)
+	DestroySession(w http.ResponseWriter, r *http.Request) error
+	Sessions(username string) ([]*Session, error)
+	DeleteSession(username, id string) error
+	DeleteSessions(username string) error
 	ChangePassword(username, password string) error
 	SaveEvent(event *model.Event) error
-	Events(limit int) ([]*model.Event, error)
+	// Events returns the newest events, only of eventType unless it is
+	// empty
+	Events(eventType string, limit int) ([]*model.Event, error)
+	EachEvent(eventType string, limit int, fn func(*model.Event) error) error
 	PurgeEvents() error
 	LogEvent(eventType, message string, tags []string)
+	Extensions() ([]*model.Extension, error)
+	Extension(name string) (*model.Extension, error)
+	SaveExtension(ext *model.Extension) error
+	DeleteExtension(ext *model.Extension) error
+	Health() map[string]string
+	Close(ctx context.Context) error
 }
 
 func NewManager(redis *redis.RedisPool, mysql *mysql.Mysql, globalSessions *session.Manager, disableUsageInfo bool,
-	authenticator auth.Authenticator) (Manager, error) {
+	authenticator auth.Authenticator, security SecurityConfig) (Manager, error) {
 
 	m := &DefaultManager{
 		authenticator:    authenticator,
@@ -83,10 +137,86 @@ func NewManager(redis *redis.RedisPool, mysql *mysql.Mysql, globalSessions *sess
 		globalSessions:   globalSessions,
 		redis:            redis,
 		mysql:            mysql,
+		queue:            newEventQueue(),
+		security:         security,
 	}
+	go m.runEventQueue()
 	return m, nil
 }
 
+// Health reports NodeHealthUp or NodeHealthDown for mysql, redis and the
+// session store. Services that are not configured are reported down.
+func (m DefaultManager) Health() map[string]string {
+	health := map[string]string{
+		"mysql":   NodeHealthDown,
+		"redis":   NodeHealthDown,
+		"session": NodeHealthDown,
+	}
+
+	if m.mysql != nil {
+		if err := m.mysql.Ping(); err == nil {
+			health["mysql"] = NodeHealthUp
+		} else {
+			log.Warnf("mysql health check failed: %s", err)
+		}
+	}
+
+	if m.redis != nil {
+		conn := m.redis.Get()
+		if _, err := conn.Do("PING"); err == nil {
+			health["redis"] = NodeHealthUp
+		} else {
+			log.Warnf("redis health check failed: %s", err)
+		}
+		conn.Close()
+	}
+
+	if m.globalSessions != nil {
+		// reading an unknown session id round trips to the session backend
+		if _, err := m.globalSessions.GetSessionStore("lillian-health-check"); err == nil {
+			health["session"] = NodeHealthUp
+		} else {
+			log.Warnf("session store health check failed: %s", err)
+		}
+	}
+
+	return health
+}
+
+// Close flushes pending events and extension notifications, then closes
+// the MySQL and Redis pools. It gives up waiting when ctx is done.
+func (m DefaultManager) Close(ctx context.Context) error {
+	m.queue.close()
+
+	drained := make(chan struct{})
+	go func() {
+		<-m.queue.done
+		m.queue.pending.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+		log.Debug("flushed pending events")
+	case <-ctx.Done():
+		err = ctx.Err()
+		log.Warnf("timeout flushing pending events: %d not written", len(m.queue.events))
+	}
+
+	if m.mysql != nil {
+		if cerr := m.mysql.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	if m.redis != nil {
+		if cerr := m.redis.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
 func (m DefaultManager) Store(w http.ResponseWriter, r *http.Request) session.Store {
 	store, err := m.globalSessions.SessionStart(w, r)
 	if err != nil {
@@ -104,22 +234,6 @@ func (m DefaultManager) Mysql() *mysql.Mysql {
 	return m.mysql
 }
 
-func (m DefaultManager) Accounts() ([]*auth.Account, error) {
-	return nil, nil
-}
-
-func (m DefaultManager) Account(username string) (*auth.Account, error) {
-	return nil, nil
-}
-
-func (m DefaultManager) SaveAccount(account *auth.Account) error {
-	return nil
-}
-
-func (m DefaultManager) DeleteAccount(account *auth.Account) error {
-	return nil
-}
-
 func (m DefaultManager) Roles() ([]*auth.ACL, error) {
 	roles := auth.DefaultACLs()
 	return roles, nil
@@ -144,69 +258,206 @@ func (m DefaultManager) GetAuthenticator() auth.Authenticator {
 	return m.authenticator
 }
 
-func (m DefaultManager) Authenticate(username, password string) (bool, error) {
-	// only get the account to get the hashed password if using the builtin auth
-	passwordHash := ""
-	if m.authenticator.Name() == "builtin" {
-		acct, err := m.Account(username)
-		if err != nil {
-			log.Error(err)
-			return false, ErrLoginFailure
+// providers returns the authenticators a login may use, in order
+func (m DefaultManager) providers() []auth.Authenticator {
+	if c, ok := m.authenticator.(*chain.ChainAuthenticator); ok {
+		return c.Providers()
+	}
+	if m.authenticator == nil {
+		return nil
+	}
+	return []auth.Authenticator{m.authenticator}
+}
+
+// passwordUpdatable reports whether lillian stores the password of
+// accounts of the provider. Accounts not yet bound to a provider can get
+// a local password.
+func (m DefaultManager) passwordUpdatable(provider string) bool {
+	if provider == "" || provider == builtinProvider {
+		return true
+	}
+	for _, p := range m.providers() {
+		if p.Name() == provider {
+			return p.IsUpdateSupported()
 		}
+	}
+	return false
+}
+
+func (m DefaultManager) Authenticate(username, password string) (bool, error) {
+	_, err := m.AuthenticateProvider(username, password)
+	return err == nil, err
+}
 
-		passwordHash = acct.Password
+// AuthenticateProvider checks the credentials and returns the
+// authenticator that accepted them. Accounts bound to a provider are only
+// checked against that provider; new and unbound users are tried against
+// every provider in order and unbound accounts are bound to the first
+// one that accepts them.
+func (m DefaultManager) AuthenticateProvider(username, password string) (auth.Authenticator, error) {
+	acct, err := m.Account(username)
+	if err != nil && err != ErrAccountDoesNotExist {
+		return nil, err
 	}
 
-	a, err := m.authenticator.Authenticate(username, password, passwordHash)
-	if !a || err != nil {
-		log.Error(ErrLoginFailure)
-		return false, ErrLoginFailure
+	for _, p := range m.providers() {
+		if acct != nil && acct.Provider != "" && acct.Provider != p.Name() {
+			continue
+		}
+
+		// only builtin needs the stored password hash
+		passwordHash := ""
+		if p.Name() == builtinProvider {
+			if acct == nil {
+				continue
+			}
+			passwordHash = acct.Password
+		}
+
+		ok, err := p.Authenticate(username, password, passwordHash)
+		if err != nil {
+			log.Debugf("%s authentication failed: username=%s err=%s", p.Name(), username, err)
+		}
+		if !ok || err != nil {
+			continue
+		}
+
+		if acct != nil && acct.Provider == "" {
+			if _, err := m.mysql.Exec(fmt.Sprintf("UPDATE %s SET provider = ? WHERE id = ?", tblNameAccounts),
+				p.Name(), acct.ID); err != nil {
+				return nil, err
+			}
+		}
+		return p, nil
 	}
 
-	return true, nil
+	log.Error(ErrLoginFailure)
+	return nil, ErrLoginFailure
 }
 
-func (m DefaultManager) NewAuthToken(username string, userAgent string) (*auth.AuthToken, error) {
-	return nil, nil
-}
+// VerifyServiceKey checks key against the service_keys table and the
+// service keys of the extensions and returns the identity it belongs
+// to. Keys from service_keys are trusted by configuration and have no
+// identity; an extension key authenticates as ExtensionUsername(name),
+// which only has the roles of an account of that name.
+func (m DefaultManager) VerifyServiceKey(key string) (string, error) {
+	if key == "" || m.mysql == nil {
+		return "", ErrServiceKeyDoesNotExist
+	}
+	rows, err := m.mysql.Query(fmt.Sprintf("SELECT '', `key` FROM %s UNION ALL SELECT name, service_key FROM %s",
+		tblNameServiceKeys, tblNameExtensions))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
 
-func (m DefaultManager) VerifyAuthToken(username, token string) error {
-	return nil
+	names, keys := []string{}, []string{}
+	for rows.Next() {
+		var name, k string
+		if err := rows.Scan(&name, &k); err != nil {
+			return "", err
+		}
+		names = append(names, name)
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	i := findServiceKey(key, keys)
+	if i < 0 {
+		return "", ErrServiceKeyDoesNotExist
+	}
+	if names[i] == "" {
+		return "", nil
+	}
+	return ExtensionUsername(names[i]), nil
 }
 
-func (m DefaultManager) VerifyServiceKey(key string) error {
-	return nil
+// ExtensionUsername is the identity requests made with the service key
+// of an extension run as
+func ExtensionUsername(name string) string {
+	return "extension:" + name
 }
 
 func (m DefaultManager) NewServiceKey(description string) (*auth.ServiceKey, error) {
 	return nil, nil
 }
 
-func (m DefaultManager) ChangePassword(username, password string) error {
-	return nil
-}
-
 func (m DefaultManager) SaveEvent(event *model.Event) error {
+	if m.mysql == nil {
+		return nil
+	}
+	tags, err := json.Marshal(event.Tags)
+	if err != nil {
+		return err
+	}
+	_, err = m.mysql.Exec(fmt.Sprintf("INSERT INTO %s (type, time, message, username, tags) VALUES (?, ?, ?, ?, ?)", tblNameEvents),
+		event.Type, event.Time, event.Message, event.Username, string(tags))
+	return err
+}
 
-	return nil
+func (m DefaultManager) Events(eventType string, limit int) ([]*model.Event, error) {
+	events := []*model.Event{}
+	err := m.EachEvent(eventType, limit, func(evt *model.Event) error {
+		events = append(events, evt)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
 }
 
-func (m DefaultManager) Events(limit int) ([]*model.Event, error) {
-	return nil, nil
+// EachEvent calls fn with the events as they are read from the database,
+// newest first, so exports do not have to hold them all in memory. An
+// error from fn stops the iteration and is returned.
+func (m DefaultManager) EachEvent(eventType string, limit int, fn func(*model.Event) error) error {
+	// the type is filtered before the limit applies, so a page is full
+	// whenever there are enough events of the type
+	rows, err := m.mysql.Query(fmt.Sprintf("SELECT type, time, message, username, tags FROM %s WHERE ? = '' OR type = ? ORDER BY time DESC LIMIT ?",
+		tblNameEvents), eventType, eventType, limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			evt  model.Event
+			tags string
+		)
+		if err := rows.Scan(&evt.Type, &evt.Time, &evt.Message, &evt.Username, &tags); err != nil {
+			return err
+		}
+		if tags != "" {
+			if err := json.Unmarshal([]byte(tags), &evt.Tags); err != nil {
+				return err
+			}
+		}
+		if err := fn(&evt); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
 
 func (m DefaultManager) PurgeEvents() error {
-	return nil
+	_, err := m.mysql.Exec(fmt.Sprintf("DELETE FROM %s", tblNameEvents))
+	return err
 }
 
 func (m DefaultManager) LogEvent(eventType, message string, tags []string) {
-	evt := &model.Event{
+	m.logEvent(&model.Event{
 		Type:    eventType,
 		Time:    time.Now(),
 		Message: message,
 		Tags:    tags,
-	}
-	if err := m.SaveEvent(evt); err != nil {
-		log.Errorf("logging event error:%s\n", err)
+	})
+}
+
+func (m DefaultManager) logEvent(evt *model.Event) {
+	if err := m.queue.enqueue(evt); err != nil {
+		log.Warnf("%s, dropping event: type=%s message=%s", err, evt.Type, evt.Message)
 	}
 }
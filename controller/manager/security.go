@@ -0,0 +1,230 @@
+package manager
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nicle-lin/lillian/helper/auth"
+	"github.com/nicle-lin/lillian/helper/auth/jwt"
+	"github.com/nicle-lin/lillian/model"
+)
+
+const (
+	tblNamePasswordHistory = "password_history"
+	failedLoginUserKey     = "lillian:login:failed:user:"
+	failedLoginIPKey       = "lillian:login:failed:ip:"
+)
+
+// SecurityConfig holds the password rules, the failed login limits and
+// the two-factor policy. A zero limit disables the corresponding lockout.
+// Accounts with one of TOTPRequiredRoles ("*" for all) have to enable
+// two-factor authentication before they can use the api. JWT issues
+// bearer tokens at login when set. Session holds the browser session
+// cookie options.
+type SecurityConfig struct {
+	PasswordPolicy       auth.PasswordPolicy
+	MaxFailedLogins      int
+	MaxFailedLoginsPerIP int
+	LockoutDuration      time.Duration
+	TOTPIssuer           string
+	TOTPRequiredRoles    []string
+	JWT                  *jwt.Issuer
+	Session              SessionConfig
+}
+
+// checkNewPassword validates a password against the policy and the
+// last PasswordPolicy.History passwords of the user
+func (m DefaultManager) checkNewPassword(username, password string) error {
+	policy := m.security.PasswordPolicy
+	if err := policy.Validate(password); err != nil {
+		return err
+	}
+	if policy.History <= 0 {
+		return nil
+	}
+
+	hashes := []string{}
+	if acct, err := m.Account(username); err == nil {
+		hashes = append(hashes, acct.Password)
+	}
+
+	// the current password counts as the most recent one
+	rows, err := m.mysql.Query(fmt.Sprintf("SELECT password FROM %s WHERE username = ? ORDER BY created_at DESC LIMIT ?",
+		tblNamePasswordHistory), username, policy.History-1)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return err
+		}
+		hashes = append(hashes, h)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return policy.CheckReuse(password, hashes)
+}
+
+// recordPasswordHistory remembers a replaced password hash and forgets
+// the ones beyond the policy's history size. The current password is
+// checked separately, so history-1 replaced hashes are kept.
+func (m DefaultManager) recordPasswordHistory(username, hash string) error {
+	keep := m.security.PasswordPolicy.History - 1
+	if keep <= 0 || hash == "" {
+		return nil
+	}
+
+	if _, err := m.mysql.Exec(fmt.Sprintf("INSERT INTO %s (username, password, created_at) VALUES (?, ?, ?)",
+		tblNamePasswordHistory), username, hash, time.Now()); err != nil {
+		return err
+	}
+
+	_, err := m.mysql.Exec(fmt.Sprintf(`DELETE FROM %s WHERE username = ? AND id NOT IN (
+    SELECT id FROM (SELECT id FROM %s WHERE username = ? ORDER BY created_at DESC LIMIT ?) recent)`,
+		tblNamePasswordHistory, tblNamePasswordHistory), username, username, keep)
+	return err
+}
+
+func redisInt(reply interface{}) int {
+	switch v := reply.(type) {
+	case int64:
+		return int(v)
+	case []byte:
+		i, _ := strconv.Atoi(string(v))
+		return i
+	case string:
+		i, _ := strconv.Atoi(v)
+		return i
+	}
+	return 0
+}
+
+// countLoginScript counts an attempt and renews the lockout window in
+// one atomic step
+const countLoginScript = `local n = redis.call('INCR', KEYS[1])
+if tonumber(ARGV[1]) > 0 then redis.call('EXPIRE', KEYS[1], ARGV[1]) end
+return n`
+
+// releaseLoginScript takes an attempt back, without recreating a counter
+// that expired in the meantime
+const releaseLoginScript = `if redis.call('EXISTS', KEYS[1]) == 1 then return redis.call('DECR', KEYS[1]) end
+return 0`
+
+// redisDoer is the part of a redis connection the login counters use
+type redisDoer interface {
+	Do(commandName string, args ...interface{}) (reply interface{}, err error)
+}
+
+type loginCounter struct {
+	key   string
+	limit int
+}
+
+// loginCounters returns the failed login counters that have a limit
+func (m DefaultManager) loginCounters(username, ip string) []loginCounter {
+	counters := []loginCounter{}
+	if m.security.MaxFailedLogins > 0 {
+		counters = append(counters, loginCounter{failedLoginUserKey + username, m.security.MaxFailedLogins})
+	}
+	if m.security.MaxFailedLoginsPerIP > 0 {
+		counters = append(counters, loginCounter{failedLoginIPKey + ip, m.security.MaxFailedLoginsPerIP})
+	}
+	return counters
+}
+
+// CheckLogin counts a login attempt against the username and the remote
+// IP and returns ErrLoginLocked once either has more attempts than its
+// limit. Attempts are counted before the password is checked, so parallel
+// guesses can not all pass the check before the first failure is
+// recorded; RecordLogin and ReleaseLogin take back the ones that did not
+// fail.
+func (m DefaultManager) CheckLogin(username, ip string) error {
+	if m.redis == nil {
+		return nil
+	}
+	conn := m.redis.Get()
+	defer conn.Close()
+	return m.countLogin(conn, username, ip)
+}
+
+func (m DefaultManager) countLogin(conn redisDoer, username, ip string) error {
+	ttl := int(m.security.LockoutDuration.Seconds())
+	locked := false
+	for _, c := range m.loginCounters(username, ip) {
+		reply, err := conn.Do("EVAL", countLoginScript, 1, c.key, ttl)
+		if err != nil {
+			log.Errorf("error counting login attempt: %s", err)
+			continue
+		}
+		n := redisInt(reply)
+		if n == c.limit+1 {
+			log.Warnf("locking out login: key=%s attempts=%d duration=%s", c.key, n, m.security.LockoutDuration)
+		}
+		if n > c.limit {
+			locked = true
+		}
+	}
+	if locked {
+		return ErrLoginLocked
+	}
+	return nil
+}
+
+// ReleaseLogin takes back an attempt counted by CheckLogin that neither
+// failed nor succeeded, such as one still waiting for its second factor
+// or one that ran into an internal error
+func (m DefaultManager) ReleaseLogin(username, ip string) {
+	if m.redis == nil {
+		return
+	}
+	conn := m.redis.Get()
+	defer conn.Close()
+	m.releaseLogin(conn, m.loginCounters(username, ip))
+}
+
+func (m DefaultManager) releaseLogin(conn redisDoer, counters []loginCounter) {
+	for _, c := range counters {
+		if _, err := conn.Do("EVAL", releaseLoginScript, 1, c.key); err != nil {
+			log.Errorf("error releasing login attempt: %s", err)
+		}
+	}
+}
+
+// RecordLogin logs the login attempt as an event. The attempt was
+// already counted by CheckLogin; a successful login resets the username
+// counter and takes the attempt back from the IP counter.
+func (m DefaultManager) RecordLogin(username, ip string, success bool) {
+	evt := &model.Event{
+		Type:     "login",
+		Time:     time.Now(),
+		Message:  fmt.Sprintf("ip=%s", ip),
+		Username: username,
+		Tags:     []string{"security"},
+	}
+	if !success {
+		evt.Type = "login-failed"
+	}
+	m.logEvent(evt)
+
+	if m.redis == nil || !success {
+		return
+	}
+	conn := m.redis.Get()
+	defer conn.Close()
+	m.resetLogin(conn, username, ip)
+}
+
+func (m DefaultManager) resetLogin(conn redisDoer, username, ip string) {
+	if _, err := conn.Do("DEL", failedLoginUserKey+username); err != nil {
+		log.Errorf("error resetting failed logins: %s", err)
+	}
+	if m.security.MaxFailedLoginsPerIP > 0 {
+		m.releaseLogin(conn, []loginCounter{{failedLoginIPKey + ip, m.security.MaxFailedLoginsPerIP}})
+	}
+}
@@ -0,0 +1,87 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nicle-lin/lillian/helper/auth"
+)
+
+func TestTOTPRequired(t *testing.T) {
+	cfg := SecurityConfig{TOTPRequiredRoles: []string{"admin"}}
+
+	cases := []struct {
+		acct     *auth.Account
+		expected bool
+	}{
+		{&auth.Account{Roles: []string{"admin"}}, true},
+		{&auth.Account{Roles: []string{"admin"}, TOTPEnabled: true}, false},
+		{&auth.Account{Roles: []string{"events:ro"}}, false},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if r := cfg.TOTPRequired(c.acct); r != c.expected {
+			t.Fatalf("expected %v for %+v; received %v", c.expected, c.acct, r)
+		}
+	}
+
+	cfg.TOTPRequiredRoles = []string{"*"}
+	if !cfg.TOTPRequired(&auth.Account{Roles: []string{"events:ro"}}) {
+		t.Fatalf("expected * to apply to every account")
+	}
+}
+
+// scriptConn runs the login counter scripts against a map
+type scriptConn struct {
+	counts map[string]int
+}
+
+func (c *scriptConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "DEL" {
+		delete(c.counts, args[0].(string))
+		return int64(1), nil
+	}
+	key := args[2].(string)
+	switch args[0] {
+	case countLoginScript:
+		c.counts[key]++
+		return int64(c.counts[key]), nil
+	case releaseLoginScript:
+		if n, ok := c.counts[key]; ok {
+			c.counts[key] = n - 1
+			return int64(n - 1), nil
+		}
+		return int64(0), nil
+	}
+	return nil, nil
+}
+
+func TestLoginCounters(t *testing.T) {
+	m := DefaultManager{security: SecurityConfig{MaxFailedLogins: 2, MaxFailedLoginsPerIP: 3, LockoutDuration: time.Minute}}
+	conn := &scriptConn{counts: map[string]int{}}
+
+	// every attempt counts before the password is checked
+	for i := 0; i < 2; i++ {
+		if err := m.countLogin(conn, "alice", "10.0.0.1"); err != nil {
+			t.Fatalf("expected attempt %d to be allowed; received %s", i+1, err)
+		}
+	}
+	if err := m.countLogin(conn, "alice", "10.0.0.1"); err != ErrLoginLocked {
+		t.Fatalf("expected ErrLoginLocked; received %v", err)
+	}
+
+	// a successful login resets the username and takes back its ip attempt
+	m.resetLogin(conn, "alice", "10.0.0.1")
+	if n := conn.counts[failedLoginUserKey+"alice"]; n != 0 {
+		t.Fatalf("expected username counter to be reset; received %d", n)
+	}
+	if n := conn.counts[failedLoginIPKey+"10.0.0.1"]; n != 2 {
+		t.Fatalf("expected 2 ip attempts; received %d", n)
+	}
+
+	// released attempts do not recreate expired counters
+	m.releaseLogin(conn, m.loginCounters("bob", "10.0.0.2"))
+	if _, ok := conn.counts[failedLoginUserKey+"bob"]; ok {
+		t.Fatal("expected no counter for bob")
+	}
+}
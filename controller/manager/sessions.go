@@ -0,0 +1,355 @@
+package manager
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	sessionIndexKey = "lillian:sessions:user:"
+	// CSRFCookieName and CSRFHeader follow the AngularJS $http
+	// convention, so the frontend sends the token without extra code
+	CSRFCookieName = "XSRF-TOKEN"
+	CSRFHeader     = "X-XSRF-TOKEN"
+	sessionIDBytes = 32
+)
+
+// SessionConfig holds the options of the browser session cookie. The
+// cookie is always HttpOnly and Secure on TLS connections or when
+// Secure is set, e.g. behind a proxy that terminates TLS.
+type SessionConfig struct {
+	CookieName string
+	Lifetime   time.Duration
+	Secure     bool
+	SameSite   http.SameSite
+	Domain     string
+}
+
+// Session is a browser login. ID identifies the session in the api; the
+// session cookie value is never exposed.
+type Session struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	Current    bool      `json:"current,omitempty"`
+	CSRFToken  string    `json:"-"`
+}
+
+// sessionEntry is kept in the per user index for listing and forced
+// logout
+type sessionEntry struct {
+	SID        string    `json:"sid"`
+	RemoteAddr string    `json:"remote_addr"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func sessionPublicID(sid string) string {
+	sum := sha256.Sum256([]byte(sid))
+	return hex.EncodeToString(sum[:16])
+}
+
+// validSessionID rejects cookie values that are not session ids, since
+// the session store uses them as redis keys
+func validSessionID(sid string) bool {
+	if len(sid) != 2*sessionIDBytes {
+		return false
+	}
+	_, err := hex.DecodeString(sid)
+	return err == nil
+}
+
+// CheckCSRF compares the token sent in the CSRF header with the one of
+// the session
+func (s *Session) CheckCSRF(r *http.Request) bool {
+	token := r.Header.Get(CSRFHeader)
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRFToken)) == 1
+}
+
+func (m DefaultManager) setSessionCookies(w http.ResponseWriter, r *http.Request, sid, csrf string, maxAge int) {
+	c := m.security.Session
+	secure := c.Secure || r.TLS != nil
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.CookieName,
+		Value:    sid,
+		Path:     "/",
+		Domain:   c.Domain,
+		MaxAge:   maxAge,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: c.SameSite,
+	})
+	// the frontend has to read the csrf token, so it is not HttpOnly
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    csrf,
+		Path:     "/",
+		Domain:   c.Domain,
+		MaxAge:   maxAge,
+		Secure:   secure,
+		SameSite: c.SameSite,
+	})
+}
+
+// NewSession logs the user in to a new browser session and sets the
+// session and csrf cookies. A session the request already had is ended,
+// so a session id planted before the login is never authenticated.
+func (m DefaultManager) NewSession(w http.ResponseWriter, r *http.Request, username string) (*Session, error) {
+	if m.globalSessions == nil {
+		return nil, ErrSessionsDisabled
+	}
+	if old, err := m.CurrentSession(r); err == nil {
+		c, _ := r.Cookie(m.security.Session.CookieName)
+		m.destroySession(old.Username, c.Value, old.ID)
+	}
+
+	sid, err := generateID(sessionIDBytes)
+	if err != nil {
+		return nil, err
+	}
+	csrf, err := generateID(sessionIDBytes)
+	if err != nil {
+		return nil, err
+	}
+	store, err := m.globalSessions.GetSessionStore(sid)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		ID:         sessionPublicID(sid),
+		Username:   username,
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+		CreatedAt:  time.Now(),
+		CSRFToken:  csrf,
+	}
+	store.Set("username", username)
+	store.Set("csrf_token", csrf)
+	store.Set("created_at", s.CreatedAt.Unix())
+	store.SessionRelease(w)
+
+	m.indexSession(s, sid)
+	m.setSessionCookies(w, r, sid, csrf, int(m.security.Session.Lifetime.Seconds()))
+	return s, nil
+}
+
+// CurrentSession returns the logged in session of the request's cookie
+func (m DefaultManager) CurrentSession(r *http.Request) (*Session, error) {
+	if m.globalSessions == nil {
+		return nil, ErrSessionsDisabled
+	}
+	c, err := r.Cookie(m.security.Session.CookieName)
+	if err != nil || !validSessionID(c.Value) {
+		return nil, ErrSessionDoesNotExist
+	}
+	store, err := m.globalSessions.GetSessionStore(c.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	username, _ := store.Get("username").(string)
+	csrf, _ := store.Get("csrf_token").(string)
+	if username == "" || csrf == "" {
+		return nil, ErrSessionDoesNotExist
+	}
+	createdAt, _ := store.Get("created_at").(int64)
+	return &Session{
+		ID:        sessionPublicID(c.Value),
+		Username:  username,
+		CreatedAt: time.Unix(createdAt, 0),
+		CSRFToken: csrf,
+	}, nil
+}
+
+// DestroySession logs the request's session out and clears its cookies
+func (m DefaultManager) DestroySession(w http.ResponseWriter, r *http.Request) error {
+	s, err := m.CurrentSession(r)
+	if err != nil {
+		return err
+	}
+	c, _ := r.Cookie(m.security.Session.CookieName)
+	m.destroySession(s.Username, c.Value, s.ID)
+	m.setSessionCookies(w, r, "", "", -1)
+	m.LogEvent("logout", fmt.Sprintf("username=%s session=%s", s.Username, s.ID), []string{"security"})
+	return nil
+}
+
+// destroySession empties the session in the store, which is enough to
+// log it out, and removes it from the user's index
+func (m DefaultManager) destroySession(username, sid, id string) {
+	if validSessionID(sid) {
+		if store, err := m.globalSessions.GetSessionStore(sid); err == nil {
+			store.Flush()
+			store.SessionRelease(nil)
+		} else {
+			log.Errorf("error ending session: %s", err)
+		}
+	}
+
+	if m.redis == nil {
+		return
+	}
+	conn := m.redis.Get()
+	defer conn.Close()
+	if _, err := conn.Do("HDEL", sessionIndexKey+username, id); err != nil {
+		log.Errorf("error removing session from index: %s", err)
+	}
+}
+
+func (m DefaultManager) indexSession(s *Session, sid string) {
+	if m.redis == nil {
+		return
+	}
+	data, err := json.Marshal(&sessionEntry{
+		SID:        sid,
+		RemoteAddr: s.RemoteAddr,
+		UserAgent:  s.UserAgent,
+		CreatedAt:  s.CreatedAt,
+	})
+	if err != nil {
+		log.Errorf("error indexing session: %s", err)
+		return
+	}
+
+	conn := m.redis.Get()
+	defer conn.Close()
+	key := sessionIndexKey + s.Username
+	if _, err := conn.Do("HSET", key, s.ID, string(data)); err != nil {
+		log.Errorf("error indexing session: %s", err)
+		return
+	}
+	// the index lives as long as the newest session
+	conn.Do("EXPIRE", key, int(m.security.Session.Lifetime.Seconds()))
+}
+
+// sessionEntries returns the indexed sessions of the user by id. Entries
+// of sessions that have expired in the store are dropped.
+func (m DefaultManager) sessionEntries(username string) (map[string]*sessionEntry, error) {
+	entries := map[string]*sessionEntry{}
+	if m.globalSessions == nil {
+		return nil, ErrSessionsDisabled
+	}
+	if m.redis == nil {
+		return entries, nil
+	}
+
+	conn := m.redis.Get()
+	defer conn.Close()
+	key := sessionIndexKey + username
+	reply, err := conn.Do("HGETALL", key)
+	if err != nil {
+		return nil, err
+	}
+	values, _ := reply.([]interface{})
+	for i := 0; i+1 < len(values); i += 2 {
+		id := redisString(values[i])
+		var e sessionEntry
+		if err := json.Unmarshal([]byte(redisString(values[i+1])), &e); err != nil || !m.sessionActive(e.SID, username) {
+			conn.Do("HDEL", key, id)
+			continue
+		}
+		entries[id] = &e
+	}
+	return entries, nil
+}
+
+func (m DefaultManager) sessionActive(sid, username string) bool {
+	if !validSessionID(sid) {
+		return false
+	}
+	store, err := m.globalSessions.GetSessionStore(sid)
+	if err != nil {
+		return false
+	}
+	u, _ := store.Get("username").(string)
+	return u == username
+}
+
+// Sessions lists the browser sessions of the user, newest first
+func (m DefaultManager) Sessions(username string) ([]*Session, error) {
+	entries, err := m.sessionEntries(username)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []*Session{}
+	for id, e := range entries {
+		sessions = append(sessions, &Session{
+			ID:         id,
+			Username:   username,
+			RemoteAddr: e.RemoteAddr,
+			UserAgent:  e.UserAgent,
+			CreatedAt:  e.CreatedAt,
+		})
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+// DeleteSession logs out one browser session of the user
+func (m DefaultManager) DeleteSession(username, id string) error {
+	entries, err := m.sessionEntries(username)
+	if err != nil {
+		return err
+	}
+	e, ok := entries[id]
+	if !ok {
+		return ErrSessionDoesNotExist
+	}
+	m.destroySession(username, e.SID, id)
+	m.LogEvent("delete-session", fmt.Sprintf("username=%s session=%s", username, id), []string{"security"})
+	return nil
+}
+
+// DeleteSessions forces the user out of every browser session. Refresh
+// tokens are revoked too, so bearer tokens stop working once they
+// expire.
+func (m DefaultManager) DeleteSessions(username string) error {
+	if err := m.endSessions(username); err != nil {
+		return err
+	}
+	m.LogEvent("delete-sessions", fmt.Sprintf("username=%s", username), []string{"security"})
+	return nil
+}
+
+// endSessions logs the user out everywhere after a password change or
+// when the account is deleted: browser sessions, refresh tokens and the
+// legacy auth tokens
+func (m DefaultManager) endSessions(username string) error {
+	if m.globalSessions != nil {
+		entries, err := m.sessionEntries(username)
+		if err != nil {
+			return err
+		}
+		for id, e := range entries {
+			m.destroySession(username, e.SID, id)
+		}
+	}
+	if err := m.revokeRefreshTokens(username); err != nil {
+		return err
+	}
+	_, err := m.mysql.Exec(fmt.Sprintf("DELETE FROM %s WHERE username = ?", tblNameAuthTokens), username)
+	return err
+}
+
+func redisString(reply interface{}) string {
+	switch v := reply.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package manager
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidSessionID(t *testing.T) {
+	sid, err := generateID(sessionIDBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !validSessionID(sid) {
+		t.Fatalf("expected generated id %s to be valid", sid)
+	}
+
+	for _, sid := range []string{"", "abc", strings.Repeat("z", 2*sessionIDBytes), sessionIndexKey + "admin"} {
+		if validSessionID(sid) {
+			t.Fatalf("expected %q to be invalid", sid)
+		}
+	}
+}
+
+func TestSessionPublicID(t *testing.T) {
+	sid, err := generateID(sessionIDBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := sessionPublicID(sid)
+	if id == sid || strings.Contains(sid, id) {
+		t.Fatalf("expected public id not to reveal the session id")
+	}
+	if id != sessionPublicID(sid) {
+		t.Fatalf("expected public id to be stable")
+	}
+}
+
+func TestCheckCSRF(t *testing.T) {
+	s := &Session{CSRFToken: "token"}
+	req, _ := http.NewRequest("POST", "/api/accounts", nil)
+	if s.CheckCSRF(req) {
+		t.Fatal("expected request without csrf header to fail")
+	}
+
+	req.Header.Set(CSRFHeader, "other")
+	if s.CheckCSRF(req) {
+		t.Fatal("expected wrong csrf token to fail")
+	}
+
+	req.Header.Set(CSRFHeader, "token")
+	if !s.CheckCSRF(req) {
+		t.Fatal("expected matching csrf token to pass")
+	}
+
+	if (&Session{}).CheckCSRF(req) {
+		t.Fatal("expected session without csrf token to fail")
+	}
+}
+
+// authTokenStore keeps the auth_tokens of a fakeDB along with a single
+// builtin account
+type authTokenStore struct {
+	tokens map[string][]string
+}
+
+func (s *authTokenStore) handlers() map[string]fakeHandler {
+	return map[string]fakeHandler{
+		"SELECT " + accountColumns: func(args []driver.Value) ([]string, [][]driver.Value, int64, error) {
+			return strings.Split(accountColumns, ", "), [][]driver.Value{
+				{"1", args[0], "", "", "", `["admin"]`, builtinProvider, "", false, "", false},
+			}, 0, nil
+		},
+		"UPDATE accounts SET password": func(args []driver.Value) ([]string, [][]driver.Value, int64, error) {
+			return nil, nil, 1, nil
+		},
+		"SELECT COUNT(*) FROM auth_tokens": func(args []driver.Value) ([]string, [][]driver.Value, int64, error) {
+			n := int64(0)
+			for _, tk := range s.tokens[args[0].(string)] {
+				if tk == args[1].(string) {
+					n++
+				}
+			}
+			return []string{"count"}, [][]driver.Value{{n}}, 0, nil
+		},
+		"DELETE FROM auth_tokens": func(args []driver.Value) ([]string, [][]driver.Value, int64, error) {
+			n := int64(len(s.tokens[args[0].(string)]))
+			delete(s.tokens, args[0].(string))
+			return nil, nil, n, nil
+		},
+	}
+}
+
+func TestChangePasswordRevokesAuthTokens(t *testing.T) {
+	store := &authTokenStore{tokens: map[string][]string{"alice": {"3f2a9c1b"}}}
+	m := DefaultManager{mysql: newFakeMysql(t, store.handlers()), queue: newEventQueue()}
+
+	if err := m.VerifyAuthToken("alice", "3f2a9c1b"); err != nil {
+		t.Fatalf("expected valid auth token; received %s", err)
+	}
+	if err := m.ChangePassword("alice", "n3w-passw0rd"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.VerifyAuthToken("alice", "3f2a9c1b"); err != ErrInvalidAuthToken {
+		t.Fatalf("expected ErrInvalidAuthToken after a password change; received %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nicle-lin/lillian/helper/auth"
+	"github.com/nicle-lin/lillian/helper/auth/totp"
+)
+
+const (
+	totpUsedKey       = "lillian:totp:used:"
+	recoveryCodeCount = 10
+)
+
+// TOTPRequired reports whether the policy forces two-factor
+// authentication on the account and it has not been enabled yet
+func (c SecurityConfig) TOTPRequired(acct *auth.Account) bool {
+	if acct == nil || acct.TOTPEnabled {
+		return false
+	}
+	for _, required := range c.TOTPRequiredRoles {
+		if required == "*" {
+			return true
+		}
+		for _, role := range acct.Roles {
+			if role == required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m DefaultManager) TOTPRequired(acct *auth.Account) bool {
+	return m.security.TOTPRequired(acct)
+}
+
+// EnrollTOTP generates a new secret for the account and returns it with
+// the otpauth URI for authenticator apps. Two-factor authentication is
+// only enabled once a code has been confirmed with EnableTOTP.
+func (m DefaultManager) EnrollTOTP(username string) (string, string, error) {
+	acct, err := m.Account(username)
+	if err != nil {
+		return "", "", err
+	}
+	if acct.TOTPEnabled {
+		return "", "", ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := m.mysql.Exec(fmt.Sprintf("UPDATE %s SET totp_secret = ? WHERE username = ?", tblNameAccounts),
+		secret, username); err != nil {
+		return "", "", err
+	}
+
+	issuer := m.security.TOTPIssuer
+	if issuer == "" {
+		issuer = "lillian"
+	}
+	return secret, totp.URI(secret, issuer, username), nil
+}
+
+// EnableTOTP confirms the enrolment with a code from the authenticator
+// app and returns the recovery codes. They are only stored hashed, so
+// this is the one time they can be shown to the user.
+func (m DefaultManager) EnableTOTP(username, code string) ([]string, error) {
+	acct, err := m.Account(username)
+	if err != nil {
+		return nil, err
+	}
+	if acct.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if acct.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if _, ok := totp.Validate(code, acct.TOTPSecret, time.Now()); !ok {
+		return nil, ErrInvalidOTP
+	}
+
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, c := range codes {
+		hashes = append(hashes, totp.HashRecoveryCode(c))
+	}
+	data, err := json.Marshal(hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := m.mysql.Exec(fmt.Sprintf("UPDATE %s SET totp_enabled = 1, recovery_codes = ? WHERE username = ?",
+		tblNameAccounts), string(data), username); err != nil {
+		return nil, err
+	}
+	m.LogEvent("enable-totp", fmt.Sprintf("username=%s", username), []string{"security"})
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off and forgets the secret
+// and the recovery codes
+func (m DefaultManager) DisableTOTP(username string) error {
+	res, err := m.mysql.Exec(fmt.Sprintf("UPDATE %s SET totp_secret = '', totp_enabled = 0, recovery_codes = '' WHERE username = ?",
+		tblNameAccounts), username)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrAccountDoesNotExist
+	}
+	m.LogEvent("disable-totp", fmt.Sprintf("username=%s", username), []string{"security"})
+	return nil
+}
+
+// VerifyTOTP checks the second factor of a login. Accounts without
+// two-factor authentication always pass. The code is either a current
+// TOTP code, which may only be used once, or an unused recovery code.
+func (m DefaultManager) VerifyTOTP(username, code string) error {
+	acct, err := m.Account(username)
+	if err == ErrAccountDoesNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !acct.TOTPEnabled {
+		return nil
+	}
+	if code == "" {
+		return ErrOTPRequired
+	}
+
+	if counter, ok := totp.Validate(code, acct.TOTPSecret, time.Now()); ok {
+		return m.markTOTPUsed(username, counter)
+	}
+	return m.useRecoveryCode(acct, code)
+}
+
+// markTOTPUsed rejects a code that has already been used within its
+// validity window
+func (m DefaultManager) markTOTPUsed(username string, counter uint64) error {
+	if m.redis == nil {
+		return nil
+	}
+	conn := m.redis.Get()
+	defer conn.Close()
+
+	ttl := (2*totp.Skew + 1) * totp.Period
+	reply, err := conn.Do("SET", fmt.Sprintf("%s%s:%d", totpUsedKey, username, counter), 1, "EX", ttl, "NX")
+	if err != nil {
+		log.Errorf("error recording used totp code: %s", err)
+		return nil
+	}
+	if reply == nil {
+		return ErrInvalidOTP
+	}
+	return nil
+}
+
+// useRecoveryCode spends a recovery code. The codes are only replaced if
+// they are still the ones acct was read with, so two logins racing with
+// the same code cannot both spend it.
+func (m DefaultManager) useRecoveryCode(acct *auth.Account, code string) error {
+	hash := totp.HashRecoveryCode(code)
+	remaining := []string{}
+	found := false
+	for _, h := range acct.RecoveryCodes {
+		if h == hash && !found {
+			found = true
+			continue
+		}
+		remaining = append(remaining, h)
+	}
+	if !found {
+		return ErrInvalidOTP
+	}
+
+	current, err := json.Marshal(acct.RecoveryCodes)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(remaining)
+	if err != nil {
+		return err
+	}
+	res, err := m.mysql.Exec(fmt.Sprintf("UPDATE %s SET recovery_codes = ? WHERE username = ? AND recovery_codes = ?", tblNameAccounts),
+		string(data), acct.Username, string(current))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n != 1 {
+		return ErrInvalidOTP
+	}
+	m.LogEvent("use-recovery-code", fmt.Sprintf("username=%s remaining=%d", acct.Username, len(remaining)),
+		[]string{"security"})
+	return nil
+}
@@ -0,0 +1,45 @@
+package manager
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+
+	"github.com/nicle-lin/lillian/helper/auth"
+	"github.com/nicle-lin/lillian/helper/auth/totp"
+)
+
+func TestUseRecoveryCodeOnce(t *testing.T) {
+	codes := []string{totp.HashRecoveryCode("aaaa-bbbb"), totp.HashRecoveryCode("cccc-dddd")}
+	data, _ := json.Marshal(codes)
+	stored := string(data)
+
+	m := DefaultManager{mysql: newFakeMysql(t, map[string]fakeHandler{
+		"UPDATE accounts SET recovery_codes = ?": func(args []driver.Value) ([]string, [][]driver.Value, int64, error) {
+			if args[2] != stored {
+				return nil, nil, 0, nil
+			}
+			stored = args[0].(string)
+			return nil, nil, 1, nil
+		},
+	}), queue: newEventQueue()}
+
+	// both logins read the account before either spends the code
+	first := &auth.Account{Username: "alice", RecoveryCodes: codes}
+	second := &auth.Account{Username: "alice", RecoveryCodes: codes}
+
+	if err := m.useRecoveryCode(first, "aaaa-bbbb"); err != nil {
+		t.Fatalf("expected the recovery code to be accepted; received %v", err)
+	}
+	if err := m.useRecoveryCode(second, "aaaa-bbbb"); err != ErrInvalidOTP {
+		t.Fatalf("expected ErrInvalidOTP when the code was already spent; received %v", err)
+	}
+
+	var remaining []string
+	if err := json.Unmarshal([]byte(stored), &remaining); err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || remaining[0] != codes[1] {
+		t.Fatalf("expected only the unused code to remain; received %v", remaining)
+	}
+}
@@ -1 +1,34 @@
 package manager
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+// generateID returns a random hex encoded identifier of n bytes
+func generateID(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// findServiceKey returns the index of key in keys, or -1. The keys are
+// hashed to the same length and all of them are compared in constant
+// time, so the time taken tells nothing about a guess.
+func findServiceKey(key string, keys []string) int {
+	if key == "" {
+		return -1
+	}
+	sum := sha256.Sum256([]byte(key))
+	found := -1
+	for i, k := range keys {
+		ks := sha256.Sum256([]byte(k))
+		match := subtle.ConstantTimeCompare(sum[:], ks[:]) & subtle.ConstantTimeEq(int32(len(k)), int32(len(key)))
+		found = subtle.ConstantTimeSelect(match, i, found)
+	}
+	return found
+}
@@ -1 +1,16 @@
 package manager
+
+import "testing"
+
+func TestFindServiceKey(t *testing.T) {
+	keys := []string{"3f2a9c1b", "", "9d8e7f60"}
+
+	if i := findServiceKey("9d8e7f60", keys); i < 0 || keys[i] != "9d8e7f60" {
+		t.Fatalf("expected stored key to match; received %d", i)
+	}
+	for _, key := range []string{"", "bogus", "9d8e7f6", "9d8e7f600"} {
+		if findServiceKey(key, keys) >= 0 {
+			t.Fatalf("expected %q not to match", key)
+		}
+	}
+}
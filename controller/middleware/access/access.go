@@ -7,15 +7,30 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/nicle-lin/lillian/controller/manager"
+	authrequired "github.com/nicle-lin/lillian/controller/middleware/auth"
+	"github.com/nicle-lin/lillian/controller/response"
 	"github.com/nicle-lin/lillian/helper/auth"
 )
 
+// selfServicePath is open to every authenticated user: password, two
+// factor and sessions of their own account
+const selfServicePath = "/api/account/"
+
 var (
 	logger = logrus.New()
 )
 
 func defaultDeniedHandler(w http.ResponseWriter, r *http.Request) {
-	http.Error(w, "拒绝访问", http.StatusForbidden)
+	response.Forbidden(w, r)
+}
+
+// unversionedPath maps /api/v1 paths onto the /api paths the acls are
+// written for
+func unversionedPath(path string) string {
+	if strings.HasPrefix(path, "/api/v1/") {
+		return "/api/" + strings.TrimPrefix(path, "/api/v1/")
+	}
+	return path
 }
 
 type AccessRequired struct {
@@ -45,31 +60,27 @@ func (a *AccessRequired) Handler(h http.Handler) http.Handler {
 	})
 }
 
+// handleRequest checks the roles of the user the auth middleware
+// authenticated, whatever the credentials were: bearer or auth tokens,
+// session cookies or client certificates
 func (a *AccessRequired) handleRequest(w http.ResponseWriter, r *http.Request) error {
-	valid := false
-	authHeader := r.Header.Get("X-Access-Token")
-	parts := strings.Split(authHeader, ":")
-	if len(parts) == 2 {
-		// validate
-		u := parts[0]
-		token := parts[1]
-		if err := a.manager.VerifyAuthToken(u, token); err == nil {
-			acct, err := a.manager.Account(u)
-			if err != nil {
-				return err
-			}
-			// check role
-			valid = a.checkAccess(acct, r.URL.Path, r.Method)
-		}
-	} else { // only check access for users; not service keys
-		valid = true
+	username := authrequired.Username(r)
+	if username == "" {
+		// whitelisted hosts and the keys in service_keys have no account,
+		// they are trusted by configuration. Extension keys run as an
+		// extension identity and go through the acl below.
+		return nil
 	}
 
-	if !valid {
+	acct, err := a.manager.Account(username)
+	if err != nil {
 		a.deniedHandler.ServeHTTP(w, r)
-		return fmt.Errorf("拒绝访问：远程地址 %s", r.RemoteAddr)
+		return err
+	}
+	if !a.checkAccess(acct, unversionedPath(r.URL.Path), r.Method) {
+		a.deniedHandler.ServeHTTP(w, r)
+		return fmt.Errorf("拒绝访问: username=%s 远程地址 %s", username, r.RemoteAddr)
 	}
-
 	return nil
 }
 
@@ -107,6 +118,11 @@ func (a *AccessRequired) checkRole(role string, path, method string) bool {
 	return false
 }
 func (a *AccessRequired) checkAccess(acct *auth.Account, path string, method string) bool {
+	// users can always manage their own account
+	if strings.HasPrefix(path, selfServicePath) {
+		return true
+	}
+
 	// check roles
 	for _, role := range acct.Roles {
 		// check acls
@@ -119,13 +135,10 @@ func (a *AccessRequired) checkAccess(acct *auth.Account, path string, method str
 }
 
 func (a *AccessRequired) HandlerFuncWithNext(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-	//err := a.handleRequest(w, r)
-	//session, _ := a.manager.Store().Get(r, a.manager.StoreKey())
-	////username := session.Values["username"]
-	//if err != nil {
-	//	logger.Warnf("access denied for %s to %s from %s", username, r.URL.Path, r.RemoteAddr)
-	//	return
-	//}
+	if err := a.handleRequest(w, r); err != nil {
+		logger.Warnf("access denied for %s to %s from %s: %s", authrequired.Username(r), r.URL.Path, r.RemoteAddr, err)
+		return
+	}
 
 	if next != nil {
 		next(w, r)
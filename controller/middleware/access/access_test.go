@@ -0,0 +1,170 @@
+package access
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nicle-lin/lillian/controller/manager"
+	authrequired "github.com/nicle-lin/lillian/controller/middleware/auth"
+	"github.com/nicle-lin/lillian/helper/auth"
+)
+
+var testHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("testing"))
+})
+
+// testManager treats bearer tokens as usernames; other methods are not
+// used by the tests and panic
+type testManager struct {
+	manager.Manager
+	accounts map[string]*auth.Account
+}
+
+func (m *testManager) VerifyJWT(token string) (string, error) {
+	if _, ok := m.accounts[token]; !ok {
+		return "", manager.ErrInvalidAuthToken
+	}
+	return token, nil
+}
+
+func (m *testManager) Account(username string) (*auth.Account, error) {
+	acct, ok := m.accounts[username]
+	if !ok {
+		return nil, manager.ErrAccountDoesNotExist
+	}
+	return acct, nil
+}
+
+// VerifyServiceKey knows the key of the crm extension
+func (m *testManager) VerifyServiceKey(key string) (string, error) {
+	if key != "crm-key" {
+		return "", manager.ErrServiceKeyDoesNotExist
+	}
+	return manager.ExtensionUsername("crm"), nil
+}
+
+func (m *testManager) TOTPRequired(acct *auth.Account) bool {
+	return false
+}
+
+var testAccounts = &testManager{accounts: map[string]*auth.Account{
+	"admin":  {Username: "admin", Roles: []string{"admin"}},
+	"bob":    {Username: "bob", Roles: []string{"events:ro"}},
+	"nobody": {Username: "nobody"},
+}}
+
+// serve runs the request through the auth and access middleware like
+// the api does
+func serve(method, path, user string) int {
+	req, _ := http.NewRequest(method, path, nil)
+	req.RemoteAddr = "10.0.0.1:40000"
+	req.Header.Set("Authorization", "Bearer "+user)
+
+	res := httptest.NewRecorder()
+	authRequired := authrequired.NewAuthRequired(testAccounts, []string{})
+	accessRequired := NewAccessRequired(testAccounts)
+	authRequired.HandlerFuncWithNext(res, req, func(w http.ResponseWriter, r *http.Request) {
+		accessRequired.HandlerFuncWithNext(w, r, testHandler)
+	})
+	return res.Code
+}
+
+func TestAccess(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		user   string
+		code   int
+	}{
+		{"GET", "/api/accounts", "admin", http.StatusOK},
+		{"GET", "/api/v1/accounts", "bob", http.StatusForbidden},
+		{"POST", "/api/accounts", "bob", http.StatusForbidden},
+		{"GET", "/api/events", "bob", http.StatusOK},
+		{"GET", "/api/v1/events", "bob", http.StatusOK},
+		{"DELETE", "/api/v1/events", "bob", http.StatusForbidden},
+		{"GET", "/api/events", "nobody", http.StatusForbidden},
+		// every user manages their own account
+		{"POST", "/api/v1/account/changepassword", "nobody", http.StatusOK},
+		{"GET", "/api/account/sessions", "bob", http.StatusOK},
+	}
+	for _, test := range tests {
+		if code := serve(test.method, test.path, test.user); code != test.code {
+			t.Fatalf("%s %s as %s: expected %d; got %d", test.method, test.path, test.user, test.code, code)
+		}
+	}
+}
+
+func TestResetTOTPRequiresAdmin(t *testing.T) {
+	for _, path := range []string{"/api/accounts/admin/totp", "/api/v1/accounts/admin/totp"} {
+		if code := serve("DELETE", path, "bob"); code != http.StatusForbidden {
+			t.Fatalf("expected 403 for non-admin on %s; got %d", path, code)
+		}
+		if code := serve("DELETE", path, "admin"); code != http.StatusOK {
+			t.Fatalf("expected 200 for admin on %s; got %d", path, code)
+		}
+	}
+	// users turn off their own second factor with a code instead
+	if code := serve("DELETE", "/api/v1/account/totp", "bob"); code != http.StatusOK {
+		t.Fatalf("expected 200 for own totp; got %d", code)
+	}
+}
+
+func TestUserSessionsRequireAdmin(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{"GET", "/api/v1/accounts/admin/sessions"},
+		{"DELETE", "/api/v1/accounts/admin/sessions"},
+		{"DELETE", "/api/v1/accounts/admin/sessions/3f2a9c1b"},
+		{"GET", "/api/accounts/bob/sessions"},
+	}
+	for _, test := range tests {
+		if code := serve(test.method, test.path, "bob"); code != http.StatusForbidden {
+			t.Fatalf("%s %s: expected 403 for non-admin; got %d", test.method, test.path, code)
+		}
+		if code := serve(test.method, test.path, "admin"); code != http.StatusOK {
+			t.Fatalf("%s %s: expected 200 for admin; got %d", test.method, test.path, code)
+		}
+	}
+
+	// users see and end their own sessions through /account
+	for _, method := range []string{"GET", "DELETE"} {
+		path := "/api/v1/account/sessions"
+		if method == "DELETE" {
+			path += "/3f2a9c1b"
+		}
+		if code := serve(method, path, "bob"); code != http.StatusOK {
+			t.Fatalf("%s %s: expected 200 for own sessions; got %d", method, path, code)
+		}
+	}
+}
+
+func TestAccessNoAccount(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/events", nil)
+	res := httptest.NewRecorder()
+
+	// a request without a user only gets here from a whitelisted host or
+	// with a service key
+	NewAccessRequired(testAccounts).HandlerFuncWithNext(res, req, testHandler)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected 200; got %d", res.Code)
+	}
+}
+
+func TestExtensionKeyAccess(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/accounts", nil)
+	req.RemoteAddr = "10.0.0.1:40000"
+	req.Header.Set("X-Service-Key", "crm-key")
+
+	res := httptest.NewRecorder()
+	authRequired := authrequired.NewAuthRequired(testAccounts, []string{})
+	accessRequired := NewAccessRequired(testAccounts)
+	authRequired.HandlerFuncWithNext(res, req, func(w http.ResponseWriter, r *http.Request) {
+		accessRequired.HandlerFuncWithNext(w, r, testHandler)
+	})
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for an extension key; got %d", res.Code)
+	}
+}
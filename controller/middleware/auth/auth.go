@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"context"
+	"crypto/x509"
 	"fmt"
 	"net"
 	"net/http"
@@ -8,14 +10,50 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/nicle-lin/lillian/controller/manager"
+	"github.com/nicle-lin/lillian/controller/response"
+	helperauth "github.com/nicle-lin/lillian/helper/auth"
+	"github.com/nicle-lin/lillian/helper/metrics"
 )
 
+type contextKey int
+
+const usernameKey contextKey = 0
+
+// totpEnrollmentPaths stay reachable for users that still have to
+// enable two-factor authentication
+var totpEnrollmentPaths = []string{"/api/account/totp", "/api/v1/account/totp"}
+
+// passwordChangePaths stay reachable for users that have to change their
+// password first
+var passwordChangePaths = []string{"/api/account/changepassword", "/api/v1/account/changepassword"}
+
 var (
 	logger = logrus.New()
+
+	// safeMethods do not change anything and need no csrf token
+	safeMethods = map[string]bool{"GET": true, "HEAD": true, "OPTIONS": true}
+
+	authFailures = metrics.Default.NewCounterVec("lillian_auth_failures_total",
+		"Requests rejected because they were not authenticated.", "method")
 )
 
+// Username returns the user the request was authenticated as. It is
+// empty for whitelisted hosts and service keys.
+func Username(r *http.Request) string {
+	username, _ := r.Context().Value(usernameKey).(string)
+	return username
+}
+
+// withUsername makes the authenticated user available to later handlers
+func withUsername(r *http.Request, username string) *http.Request {
+	if username == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), usernameKey, username))
+}
+
 func defaultDeniedHostHandler(w http.ResponseWriter, r *http.Request) {
-	http.Error(w, "没有认证", http.StatusUnauthorized)
+	response.Unauthorized(w, r)
 }
 
 type AuthRequired struct {
@@ -34,12 +72,12 @@ func NewAuthRequired(m manager.Manager, whitelistCIDRs []string) *AuthRequired {
 
 func (a *AuthRequired) Handler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		err := a.handleRequest(w, r)
+		username, err := a.handleRequest(w, r)
 		if err != nil {
 			logger.Warnf("无效的认证请求 %s from %s", r.URL.Path, r.RemoteAddr)
 			return
 		}
-		h.ServeHTTP(w, r)
+		h.ServeHTTP(w, withUsername(r, username))
 	})
 }
 
@@ -64,26 +102,79 @@ func (a *AuthRequired) isWhitelisted(addr string) (bool, error) {
 	return false, nil
 }
 
-func (a *AuthRequired) handleRequest(w http.ResponseWriter, r *http.Request) error {
-	return nil
+// clientCertUsername returns the common name of a verified client
+// certificate, which is used as the account username for service calls.
+// Certificates are only verified when tlsVerifyClients is set, and only
+// against the configured ca; they also have to be issued for client
+// authentication.
+func clientCertUsername(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	for _, usage := range leaf.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageClientAuth {
+			return leaf.Subject.CommonName
+		}
+	}
+	return ""
+}
+
+// authMethod names the credentials a request tried to authenticate with
+func authMethod(r *http.Request) string {
+	switch {
+	case clientCertUsername(r) != "":
+		return "client_cert"
+	case r.Header.Get("X-Service-Key") != "":
+		return "service_key"
+	case r.Header.Get("Authorization") != "":
+		return "bearer"
+	case r.Header.Get("X-Access-Token") != "":
+		return "access_token"
+	case len(r.Cookies()) > 0:
+		return "session"
+	}
+	return "none"
+}
+
+// handleRequest checks the credentials of the request and returns the
+// user it was made by
+func (a *AuthRequired) handleRequest(w http.ResponseWriter, r *http.Request) (string, error) {
 	whitelisted, err := a.isWhitelisted(r.RemoteAddr)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	if whitelisted {
-		return nil
+		return "", nil
 	}
 
 	valid := false
-	// service key takes priority
+	username := ""
+	tokenUser := ""
+	// verified client certificates take priority, then service keys
 	serviceKey := r.Header.Get("X-Service-Key")
-	if serviceKey != "" {
-		if err := a.manager.VerifyServiceKey(serviceKey); err == nil {
+	if cn := clientCertUsername(r); cn != "" {
+		if _, err := a.manager.Account(cn); err == nil {
+			valid = true
+			username = cn
+		} else {
+			logger.Warnf("no account for client certificate: cn=%s", cn)
+		}
+	} else if serviceKey != "" {
+		if user, err := a.manager.VerifyServiceKey(serviceKey); err == nil {
+			valid = true
+			username = user
+		}
+	} else if bearer, err := helperauth.GetBearerToken(r.Header.Get("Authorization")); err == nil {
+		// signed access tokens need no database lookup
+		if user, err := a.manager.VerifyJWT(bearer); err == nil {
 			valid = true
+			tokenUser = user
+		} else {
+			logger.Debugf("invalid bearer token from %s: %s", r.RemoteAddr, err)
 		}
-	} else { // check for authHeader
-		authHeader := r.Header.Get("X-Access-Token")
+	} else if authHeader := r.Header.Get("X-Access-Token"); authHeader != "" { // check for the legacy authHeader
 		parts := strings.Split(authHeader, ":")
 		if len(parts) == 2 {
 			// validate
@@ -91,24 +182,93 @@ func (a *AuthRequired) handleRequest(w http.ResponseWriter, r *http.Request) err
 			token := parts[1]
 			if err := a.manager.VerifyAuthToken(user, token); err == nil {
 				valid = true
+				tokenUser = user
 				// set current user
 				//session, _ := a.manager.Store().Get(r, a.manager.StoreKey())
 				//session.Values["username"] = user
 				//session.Save(r, w)
 			}
 		}
+	} else if session, err := a.currentSession(r); err == nil {
+		// browsers send the session cookie along with any request, so
+		// changes need the csrf token as well
+		if safeMethods[r.Method] || session.CheckCSRF(r) {
+			valid = true
+			tokenUser = session.Username
+		} else {
+			response.WriteError(w, r, http.StatusForbidden, &response.Error{
+				Code:             "invalid_csrf_token",
+				Message:          "invalid csrf token",
+				LocalizedMessage: manager.ErrInvalidCSRFToken.Error(),
+			})
+			return "", fmt.Errorf("无效的 CSRF 令牌，远程地址： %s", r.RemoteAddr)
+		}
 	}
 
 	if !valid {
+		authFailures.Inc(authMethod(r))
 		a.deniedHostHandler.ServeHTTP(w, r)
-		return fmt.Errorf("没有认证，远程地址： %s", r.RemoteAddr)
+		return "", fmt.Errorf("没有认证，远程地址： %s", r.RemoteAddr)
+	}
+
+	if tokenUser != "" {
+		return tokenUser, a.checkAccountRestrictions(w, r, tokenUser)
+	}
+	return username, nil
+}
+
+// currentSession returns the browser session of the request; requests
+// without cookies are not looked up
+func (a *AuthRequired) currentSession(r *http.Request) (*manager.Session, error) {
+	if len(r.Cookies()) == 0 {
+		return nil, manager.ErrSessionDoesNotExist
 	}
+	return a.manager.CurrentSession(r)
+}
 
-	return nil
+func hasPathPrefix(path string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAccountRestrictions restricts users that have to change their
+// password to the password change, and then users the two-factor policy
+// applies to to the enrolment endpoints until they have enabled it
+func (a *AuthRequired) checkAccountRestrictions(w http.ResponseWriter, r *http.Request, username string) error {
+	acct, err := a.manager.Account(username)
+	if err != nil {
+		return nil
+	}
+
+	if acct.PasswordChangeRequired {
+		if hasPathPrefix(r.URL.Path, passwordChangePaths) {
+			return nil
+		}
+		response.WriteError(w, r, http.StatusForbidden, &response.Error{
+			Code:             "password_change_required",
+			Message:          "the password has to be changed first",
+			LocalizedMessage: manager.ErrPasswordChangeRequired.Error(),
+		})
+		return fmt.Errorf("需要先修改密码: username=%s", username)
+	}
+
+	if hasPathPrefix(r.URL.Path, totpEnrollmentPaths) || !a.manager.TOTPRequired(acct) {
+		return nil
+	}
+	response.WriteError(w, r, http.StatusForbidden, &response.Error{
+		Code:             "totp_enrollment_required",
+		Message:          "two-factor authentication has to be enabled first",
+		LocalizedMessage: manager.ErrTOTPEnrollmentRequired.Error(),
+	})
+	return fmt.Errorf("两步验证未启用: username=%s", username)
 }
 
 func (a *AuthRequired) HandlerFuncWithNext(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-	err := a.handleRequest(w, r)
+	username, err := a.handleRequest(w, r)
 
 	if err != nil {
 		logger.Warnf("无效认证请求 %s from %s", r.URL.Path, r.RemoteAddr)
@@ -116,6 +276,6 @@ func (a *AuthRequired) HandlerFuncWithNext(w http.ResponseWriter, r *http.Reques
 	}
 
 	if next != nil {
-		next(w, r)
+		next(w, withUsername(r, username))
 	}
 }
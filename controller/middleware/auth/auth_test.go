@@ -1,9 +1,15 @@
 package auth
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/nicle-lin/lillian/controller/manager"
+	helperauth "github.com/nicle-lin/lillian/helper/auth"
 )
 
 var testHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -18,7 +24,40 @@ func TestNoAuthToken(t *testing.T) {
 	a.Handler(testHandler).ServeHTTP(res, req)
 
 	if res.Code != http.StatusUnauthorized {
-		t.Fatalf("expected 401; got %s", res.Code)
+		t.Fatalf("expected 401; got %d", res.Code)
+	}
+}
+
+// testManager accepts a single service key; other methods are not used
+// by the tests and panic
+type testManager struct {
+	manager.Manager
+	serviceKey string
+}
+
+func (m *testManager) VerifyServiceKey(key string) (string, error) {
+	if key != m.serviceKey {
+		return "", manager.ErrServiceKeyDoesNotExist
+	}
+	return "", nil
+}
+
+func TestServiceKey(t *testing.T) {
+	a := NewAuthRequired(&testManager{serviceKey: "3f2a9c1b"}, []string{})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/accounts", nil)
+	req.Header.Set("X-Service-Key", "bogus")
+	a.Handler(testHandler).ServeHTTP(res, req)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for unknown service key; got %d", res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	req.Header.Set("X-Service-Key", "3f2a9c1b")
+	a.Handler(testHandler).ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected 200 for valid service key; got %d", res.Code)
 	}
 }
 
@@ -55,3 +94,89 @@ func TestWhiteListInvalid(t *testing.T) {
 		t.Fatalf("expected 401; got %d", res.Code)
 	}
 }
+
+func TestClientCertUsername(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	if u := clientCertUsername(req); u != "" {
+		t.Fatalf("expected no username without tls; got %s", u)
+	}
+
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{
+			{{Subject: pkix.Name{CommonName: "billing-service"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}},
+		},
+	}
+	if u := clientCertUsername(req); u != "billing-service" {
+		t.Fatalf("expected username billing-service; got %s", u)
+	}
+
+	// a server certificate does not log anybody in
+	req.TLS.VerifiedChains[0][0].ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	if u := clientCertUsername(req); u != "" {
+		t.Fatalf("expected no username for a server certificate; got %s", u)
+	}
+}
+
+func TestWhiteListNoUsername(t *testing.T) {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:40000"
+
+	username := "unset"
+	a := NewAuthRequired(nil, []string{"0.0.0.0/0"})
+	a.HandlerFuncWithNext(res, req, func(w http.ResponseWriter, r *http.Request) {
+		username = Username(r)
+	})
+
+	if username != "" {
+		t.Fatalf("expected no username for whitelisted host; got %s", username)
+	}
+
+	req = withUsername(req, "admin")
+	if u := Username(req); u != "admin" {
+		t.Fatalf("expected username admin; got %s", u)
+	}
+}
+
+// restrictedManager authenticates bearer tokens as the account of the
+// same name
+type restrictedManager struct {
+	manager.Manager
+	accounts map[string]*helperauth.Account
+}
+
+func (m *restrictedManager) VerifyJWT(token string) (string, error) {
+	if _, ok := m.accounts[token]; !ok {
+		return "", manager.ErrInvalidAuthToken
+	}
+	return token, nil
+}
+
+func (m *restrictedManager) Account(username string) (*helperauth.Account, error) {
+	return m.accounts[username], nil
+}
+
+func (m *restrictedManager) TOTPRequired(acct *helperauth.Account) bool {
+	return false
+}
+
+func TestPasswordChangeRequired(t *testing.T) {
+	a := NewAuthRequired(&restrictedManager{accounts: map[string]*helperauth.Account{
+		"admin": {Username: "admin", PasswordChangeRequired: true},
+	}}, []string{})
+
+	for path, expected := range map[string]int{
+		"/api/accounts":                  http.StatusForbidden,
+		"/api/v1/account/totp":           http.StatusForbidden,
+		"/api/v1/account/changepassword": http.StatusOK,
+		"/api/account/changepassword":    http.StatusOK,
+	} {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, nil)
+		req.Header.Set("Authorization", "Bearer admin")
+		a.Handler(testHandler).ServeHTTP(res, req)
+		if res.Code != expected {
+			t.Fatalf("expected %d for %s; got %d", expected, path, res.Code)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package cors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var (
+	logger = logrus.New()
+)
+
+// Config lists what other origins may do with the api. An empty
+// AllowedOrigins disables CORS; "*" allows any origin but can not be
+// combined with AllowCredentials.
+type Config struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           int
+}
+
+type CORS struct {
+	config  Config
+	origins map[string]bool
+	methods map[string]bool
+	headers map[string]bool
+	any     bool
+}
+
+func NewCORS(config Config) *CORS {
+	c := &CORS{
+		config:  config,
+		origins: map[string]bool{},
+		methods: map[string]bool{},
+		headers: map[string]bool{},
+	}
+	for _, o := range config.AllowedOrigins {
+		if o == "*" {
+			c.any = true
+			continue
+		}
+		c.origins[strings.ToLower(strings.TrimSuffix(o, "/"))] = true
+	}
+	for _, m := range config.AllowedMethods {
+		c.methods[strings.ToUpper(m)] = true
+	}
+	for _, h := range config.AllowedHeaders {
+		c.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	return c
+}
+
+func (c *CORS) originAllowed(origin string) bool {
+	return c.any || c.origins[strings.ToLower(origin)]
+}
+
+// headersAllowed checks the comma separated Access-Control-Request-Headers
+func (c *CORS) headersAllowed(requested string) bool {
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h != "" && !c.headers[http.CanonicalHeaderKey(h)] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *CORS) setOrigin(w http.ResponseWriter, origin string) {
+	h := w.Header()
+	if c.any && !c.config.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.config.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight answers the OPTIONS request browsers send before a cross
+// origin request. It never reaches the auth middleware, since browsers
+// send no credentials with it.
+func (c *CORS) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	requested := r.Header.Get("Access-Control-Request-Headers")
+	if !c.originAllowed(origin) || !c.methods[method] || !c.headersAllowed(requested) {
+		logger.Warnf("拒绝跨域请求: origin=%s method=%s headers=%s", origin, method, requested)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	c.setOrigin(w, origin)
+	h.Set("Access-Control-Allow-Methods", strings.Join(c.config.AllowedMethods, ", "))
+	if len(c.config.AllowedHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(c.config.AllowedHeaders, ", "))
+	}
+	if c.config.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(c.config.MaxAge))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *CORS) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.HandlerFuncWithNext(w, r, h.ServeHTTP)
+	})
+}
+
+func (c *CORS) HandlerFuncWithNext(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	// responses depend on the origin, so caches have to keep them apart
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if origin != "" && r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+		c.preflight(w, r, origin)
+		return
+	}
+
+	// requests from other origins are still served; without the headers
+	// the browser does not let the page read the response
+	if origin != "" && c.originAllowed(origin) {
+		c.setOrigin(w, origin)
+		if len(c.config.ExposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.config.ExposedHeaders, ", "))
+		}
+	}
+
+	if next != nil {
+		next(w, r)
+	}
+}
@@ -0,0 +1,114 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var testHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("testing"))
+})
+
+var testConfig = Config{
+	AllowedOrigins:   []string{"https://ui.example.com"},
+	AllowedMethods:   []string{"GET", "POST", "DELETE"},
+	AllowedHeaders:   []string{"Content-Type", "Authorization", "X-XSRF-TOKEN"},
+	ExposedHeaders:   []string{"X-Lillian-OTP"},
+	AllowCredentials: true,
+	MaxAge:           600,
+}
+
+func preflight(origin, method, headers string) *http.Request {
+	req, _ := http.NewRequest("OPTIONS", "/api/accounts", nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		req.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	return req
+}
+
+func TestPreflight(t *testing.T) {
+	res := httptest.NewRecorder()
+	NewCORS(testConfig).Handler(testHandler).ServeHTTP(res, preflight("https://ui.example.com", "POST", "content-type, x-xsrf-token"))
+
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("expected 204; got %d", res.Code)
+	}
+	if res.Body.Len() != 0 {
+		t.Fatalf("expected preflight not to reach the handler; got %q", res.Body.String())
+	}
+	h := res.Header()
+	if v := h.Get("Access-Control-Allow-Origin"); v != "https://ui.example.com" {
+		t.Fatalf("expected allowed origin; got %q", v)
+	}
+	if v := h.Get("Access-Control-Allow-Credentials"); v != "true" {
+		t.Fatalf("expected credentials to be allowed; got %q", v)
+	}
+	if v := h.Get("Access-Control-Allow-Methods"); v != "GET, POST, DELETE" {
+		t.Fatalf("expected allowed methods; got %q", v)
+	}
+	if v := h.Get("Access-Control-Max-Age"); v != "600" {
+		t.Fatalf("expected max age 600; got %q", v)
+	}
+}
+
+func TestPreflightRejected(t *testing.T) {
+	for _, req := range []*http.Request{
+		preflight("https://evil.example.com", "POST", ""),
+		preflight("https://ui.example.com", "PUT", ""),
+		preflight("https://ui.example.com", "POST", "X-Service-Key"),
+	} {
+		res := httptest.NewRecorder()
+		NewCORS(testConfig).Handler(testHandler).ServeHTTP(res, req)
+
+		if res.Code != http.StatusForbidden {
+			t.Fatalf("expected 403; got %d", res.Code)
+		}
+		if v := res.Header().Get("Access-Control-Allow-Origin"); v != "" {
+			t.Fatalf("expected no allowed origin; got %q", v)
+		}
+	}
+}
+
+func TestSimpleRequest(t *testing.T) {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/accounts", nil)
+	req.Header.Set("Origin", "https://UI.example.com")
+	NewCORS(testConfig).Handler(testHandler).ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK || res.Body.String() != "testing" {
+		t.Fatalf("expected request to reach the handler; got %d %q", res.Code, res.Body.String())
+	}
+	if v := res.Header().Get("Access-Control-Allow-Origin"); v != "https://UI.example.com" {
+		t.Fatalf("expected allowed origin; got %q", v)
+	}
+	if v := res.Header().Get("Access-Control-Expose-Headers"); v != "X-Lillian-OTP" {
+		t.Fatalf("expected exposed headers; got %q", v)
+	}
+
+	res = httptest.NewRecorder()
+	req.Header.Set("Origin", "https://evil.example.com")
+	NewCORS(testConfig).Handler(testHandler).ServeHTTP(res, req)
+	if v := res.Header().Get("Access-Control-Allow-Origin"); v != "" {
+		t.Fatalf("expected no allowed origin; got %q", v)
+	}
+	if v := res.Header().Get("Vary"); v != "Origin" {
+		t.Fatalf("expected Vary: Origin; got %q", v)
+	}
+}
+
+func TestAnyOrigin(t *testing.T) {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/accounts", nil)
+	req.Header.Set("Origin", "https://other.example.com")
+	NewCORS(Config{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}}).Handler(testHandler).ServeHTTP(res, req)
+
+	if v := res.Header().Get("Access-Control-Allow-Origin"); v != "*" {
+		t.Fatalf("expected any origin; got %q", v)
+	}
+	if v := res.Header().Get("Access-Control-Allow-Credentials"); v != "" {
+		t.Fatalf("expected no credentials for any origin; got %q", v)
+	}
+}
@@ -0,0 +1,58 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+// Header carries the request id; ids sent by a proxy in front of lillian
+// are kept so logs can be matched across both
+const Header = "X-Request-ID"
+
+type contextKey int
+
+const requestIDKey contextKey = 0
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type RequestID struct{}
+
+func NewRequestID() *RequestID {
+	return &RequestID{}
+}
+
+// FromRequest returns the id of the request or an empty string when it
+// did not pass through the middleware
+func FromRequest(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+func (m *RequestID) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.HandlerFuncWithNext(w, r, h.ServeHTTP)
+	})
+}
+
+func (m *RequestID) HandlerFuncWithNext(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	id := r.Header.Get(Header)
+	if !validID.MatchString(id) {
+		id = newID()
+	}
+	w.Header().Set(Header, id)
+
+	if next != nil {
+		next(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	}
+}
@@ -0,0 +1,41 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serve(req *http.Request) (*httptest.ResponseRecorder, string) {
+	id := ""
+	res := httptest.NewRecorder()
+	NewRequestID().Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = FromRequest(r)
+	})).ServeHTTP(res, req)
+	return res, id
+}
+
+func TestNewRequestID(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/accounts", nil)
+	res, id := serve(req)
+
+	if id == "" {
+		t.Fatal("expected a request id")
+	}
+	if v := res.Header().Get(Header); v != id {
+		t.Fatalf("expected response header %s; got %s", id, v)
+	}
+}
+
+func TestForwardedRequestID(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/accounts", nil)
+	req.Header.Set(Header, "proxy-1234")
+	if _, id := serve(req); id != "proxy-1234" {
+		t.Fatalf("expected forwarded id proxy-1234; got %s", id)
+	}
+
+	req.Header.Set(Header, "bad id\nInjected: true")
+	if _, id := serve(req); id == "" || id == req.Header.Get(Header) {
+		t.Fatalf("expected invalid id to be replaced; got %q", id)
+	}
+}
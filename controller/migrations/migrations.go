@@ -0,0 +1,445 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	tblNameMigrations = "schema_migrations"
+	tblNameSteps      = "schema_migration_steps"
+
+	// lockName is the MySQL named lock held while migrating, so instances
+	// started together do not run the same statements twice
+	lockName    = "lillian_migrations"
+	lockTimeout = 60 * time.Second
+)
+
+var (
+	ErrInvalidMigration = errors.New("无效的数据库迁移文件")
+	ErrDirtyDatabase    = errors.New("数据库中有未知的迁移版本, 请升级 lillian")
+	ErrLocked           = errors.New("其他实例正在迁移数据库, 请稍后重试")
+
+	migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+)
+
+//go:embed sql/*.sql
+var embedded embed.FS
+
+// Migration is one versioned schema change with the SQL to apply and
+// to revert it
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration along with when it was applied, nil if pending
+type Status struct {
+	*Migration
+	AppliedAt *time.Time
+}
+
+// Load returns the migrations embedded in the binary ordered by version
+func Load() ([]*Migration, error) {
+	return loadFS(embedded, "sql")
+}
+
+func loadFS(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		parts := migrationName.FindStringSubmatch(e.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("%s: %s", ErrInvalidMigration, e.Name())
+		}
+		version, _ := strconv.Atoi(parts[1])
+
+		body, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		}
+		if m.Name != parts[2] {
+			return nil, fmt.Errorf("%s: version %d has names %s and %s", ErrInvalidMigration, version, m.Name, parts[2])
+		}
+		if parts[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := []*Migration{}
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("%s: version %d needs both up and down files", ErrInvalidMigration, m.Version)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// statements splits a migration into single statements, since the
+// driver does not run multiple statements in one Exec by default. Like
+// the mysql client, semicolons in quoted text and comments do not end a
+// statement, and a DELIMITER line changes the delimiter, so trigger and
+// procedure bodies can be written between DELIMITER $$ and DELIMITER ;
+func statements(body string) []string {
+	stmts := []string{}
+	delimiter := ";"
+	start := 0
+	// content is set once the current statement has more than whitespace
+	// and comments, which mysql would reject as an empty query
+	content := false
+	emit := func(end int) {
+		if content {
+			stmts = append(stmts, strings.TrimSpace(body[start:end]))
+		}
+		content = false
+	}
+
+	for i := 0; i < len(body); {
+		rest := body[i:]
+		switch {
+		case !content && isDelimiterCommand(rest):
+			end := lineEnd(body, i)
+			delimiter = strings.TrimSpace(body[i+len("DELIMITER") : end])
+			i, start = end, end
+		case rest[0] == '\'' || rest[0] == '"' || rest[0] == '`':
+			i = quoteEnd(body, i)
+			content = true
+		case rest[0] == '#' || strings.HasPrefix(rest, "--") && (len(rest) == 2 || isSpace(rest[2])):
+			i = lineEnd(body, i)
+		case strings.HasPrefix(rest, "/*"):
+			// /*! ... */ is run by mysql, so it counts as content
+			if strings.HasPrefix(rest, "/*!") {
+				content = true
+			}
+			if end := strings.Index(rest[2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(body)
+			}
+		case delimiter != "" && strings.HasPrefix(rest, delimiter):
+			emit(i)
+			i += len(delimiter)
+			start = i
+		default:
+			if !isSpace(rest[0]) {
+				content = true
+			}
+			i++
+		}
+	}
+	emit(len(body))
+	return stmts
+}
+
+// isDelimiterCommand reports whether s starts with a DELIMITER command
+func isDelimiterCommand(s string) bool {
+	const cmd = "DELIMITER"
+	return len(s) > len(cmd) && strings.EqualFold(s[:len(cmd)], cmd) && (s[len(cmd)] == ' ' || s[len(cmd)] == '\t')
+}
+
+// quoteEnd returns the index after the quoted text starting at i. Quotes
+// are escaped by doubling them, or with a backslash outside backticks.
+func quoteEnd(body string, i int) int {
+	quote := body[i]
+	for j := i + 1; j < len(body); j++ {
+		switch {
+		case body[j] == '\\' && quote != '`':
+			j++
+		case body[j] == quote:
+			if j+1 < len(body) && body[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(body)
+}
+
+// lineEnd returns the index of the newline ending the line at i
+func lineEnd(body string, i int) int {
+	if end := strings.IndexByte(body[i:], '\n'); end >= 0 {
+		return i + end
+	}
+	return len(body)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []*Migration
+}
+
+// NewMigrator returns a migrator for the embedded migrations and creates
+// the schema_migrations table if needed
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    version INT NOT NULL,
+    name VARCHAR(191) NOT NULL,
+    applied_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (version)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, tblNameMigrations)); err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    version INT NOT NULL,
+    direction VARCHAR(4) NOT NULL,
+    step INT NOT NULL,
+    PRIMARY KEY (version, direction, step)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, tblNameSteps)); err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}, nil
+}
+
+func (m *Migrator) applied() (map[int]time.Time, error) {
+	rows, err := m.db.Query(fmt.Sprintf("SELECT version, applied_at FROM %s", tblNameMigrations))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// Status lists every known migration and when it was applied
+func (m *Migrator) Status() ([]*Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	status := []*Status{}
+	for _, mg := range m.migrations {
+		s := &Status{Migration: mg}
+		if t, ok := applied[mg.Version]; ok {
+			s.AppliedAt = &t
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// lock takes the migration lock on a dedicated connection, since MySQL
+// named locks belong to the session that took them. The returned func
+// releases it.
+func (m *Migrator) lock() (func(), error) {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, int(lockTimeout.Seconds())).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !locked.Valid || locked.Int64 != 1 {
+		conn.Close()
+		return nil, ErrLocked
+	}
+
+	return func() {
+		var released sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT RELEASE_LOCK(?)", lockName).Scan(&released); err != nil {
+			log.Errorf("error releasing migration lock: %s", err)
+		}
+		conn.Close()
+	}, nil
+}
+
+// Up applies all pending migrations in order and returns them
+func (m *Migrator) Up() ([]*Migration, error) {
+	unlock, err := m.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	for v := range applied {
+		if !m.known(v) {
+			return nil, fmt.Errorf("%s: version %d", ErrDirtyDatabase, v)
+		}
+	}
+
+	done := []*Migration{}
+	for _, mg := range m.migrations {
+		if _, ok := applied[mg.Version]; ok {
+			continue
+		}
+		log.Infof("applying migration: version=%d name=%s", mg.Version, mg.Name)
+		if err := m.run(mg.Version, "up", mg.Up); err != nil {
+			return done, fmt.Errorf("migration %d_%s: %s", mg.Version, mg.Name, err)
+		}
+		if err := m.finish(mg.Version, fmt.Sprintf("INSERT INTO %s (version, name, applied_at) VALUES (?, ?, ?)", tblNameMigrations),
+			mg.Version, mg.Name, time.Now()); err != nil {
+			return done, err
+		}
+		done = append(done, mg)
+	}
+	return done, nil
+}
+
+// Down reverts the last steps applied migrations and returns them
+func (m *Migrator) Down(steps int) ([]*Migration, error) {
+	unlock, err := m.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	done := []*Migration{}
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		mg := m.migrations[i]
+		if _, ok := applied[mg.Version]; !ok {
+			continue
+		}
+		log.Infof("reverting migration: version=%d name=%s", mg.Version, mg.Name)
+		if err := m.run(mg.Version, "down", mg.Down); err != nil {
+			return done, fmt.Errorf("migration %d_%s: %s", mg.Version, mg.Name, err)
+		}
+		if err := m.finish(mg.Version, fmt.Sprintf("DELETE FROM %s WHERE version = ?", tblNameMigrations), mg.Version); err != nil {
+			return done, err
+		}
+		done = append(done, mg)
+	}
+	return done, nil
+}
+
+func (m *Migrator) known(version int) bool {
+	for _, mg := range m.migrations {
+		if mg.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// run executes the statements of a migration, recording each one in
+// schema_migration_steps as it succeeds. MySQL commits DDL implicitly, so
+// a migration that fails halfway can not be rolled back; the next run
+// resumes after the last recorded statement instead of repeating it.
+func (m *Migrator) run(version int, direction, body string) error {
+	rows, err := m.db.Query(fmt.Sprintf("SELECT step FROM %s WHERE version = ? AND direction = ?", tblNameSteps),
+		version, direction)
+	if err != nil {
+		return err
+	}
+	done := map[int]bool{}
+	for rows.Next() {
+		var step int
+		if err := rows.Scan(&step); err != nil {
+			rows.Close()
+			return err
+		}
+		done[step] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, stmt := range statements(body) {
+		if done[i] {
+			log.Infof("skipping applied statement: version=%d direction=%s step=%d", version, direction, i)
+			continue
+		}
+		tx, err := m.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("statement %d: %s", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (version, direction, step) VALUES (?, ?, ?)", tblNameSteps),
+			version, direction, i); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish records a completed migration with query and clears its steps
+func (m *Migrator) finish(version int, query string, args ...interface{}) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(query, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE version = ?", tblNameSteps), version); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,99 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadEmbedded(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) == 0 {
+		t.Fatal("expected embedded migrations")
+	}
+
+	for i, m := range migrations {
+		if i > 0 && m.Version <= migrations[i-1].Version {
+			t.Fatalf("expected migrations ordered by version; %d after %d", m.Version, migrations[i-1].Version)
+		}
+		if len(statements(m.Up)) == 0 || len(statements(m.Down)) == 0 {
+			t.Fatalf("expected statements in migration %d_%s", m.Version, m.Name)
+		}
+	}
+}
+
+func TestLoadMissingDown(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/0001_init.up.sql": {Data: []byte("CREATE TABLE a (id INT)")},
+	}
+	if _, err := loadFS(fsys, "sql"); err == nil {
+		t.Fatal("expected error for migration without down file")
+	}
+}
+
+func TestLoadInvalidName(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/init.sql": {Data: []byte("CREATE TABLE a (id INT)")},
+	}
+	if _, err := loadFS(fsys, "sql"); err == nil {
+		t.Fatal("expected error for invalid migration name")
+	}
+}
+
+func TestStatements(t *testing.T) {
+	stmts := statements("CREATE TABLE a (id INT);\n\nCREATE TABLE b (id INT);\n")
+	if len(stmts) != 2 {
+		t.Fatalf("expected 2 statements; received %d", len(stmts))
+	}
+	if stmts[1] != "CREATE TABLE b (id INT)" {
+		t.Fatalf("unexpected statement %q", stmts[1])
+	}
+}
+
+func TestStatementsQuotedAndComments(t *testing.T) {
+	body := `-- settings; keep them together
+CREATE TABLE a (
+    name VARCHAR(32) NOT NULL DEFAULT 'a;b' COMMENT 'it''s; quoted',
+    note VARCHAR(32) NOT NULL DEFAULT "c\";d"
+) COMMENT = 'table; comment';
+/* not; a statement */
+INSERT INTO ` + "`a;b`" + ` (name) VALUES ('x'); # trailing; comment
+-- nothing after the last statement;
+`
+	stmts := statements(body)
+	if len(stmts) != 2 {
+		t.Fatalf("expected 2 statements; received %d: %q", len(stmts), stmts)
+	}
+	if !strings.HasSuffix(stmts[0], "COMMENT = 'table; comment'") {
+		t.Fatalf("unexpected statement %q", stmts[0])
+	}
+	if !strings.HasSuffix(stmts[1], "VALUES ('x')") {
+		t.Fatalf("unexpected statement %q", stmts[1])
+	}
+}
+
+func TestStatementsDelimiter(t *testing.T) {
+	body := `DELIMITER $$
+CREATE TRIGGER a_bi BEFORE INSERT ON a FOR EACH ROW
+BEGIN
+    SET NEW.name = LOWER(NEW.name);
+    SET NEW.note = '';
+END$$
+DELIMITER ;
+DROP TABLE b;
+`
+	stmts := statements(body)
+	if len(stmts) != 2 {
+		t.Fatalf("expected 2 statements; received %d: %q", len(stmts), stmts)
+	}
+	if !strings.HasPrefix(stmts[0], "CREATE TRIGGER") || !strings.HasSuffix(stmts[0], "END") {
+		t.Fatalf("unexpected statement %q", stmts[0])
+	}
+	if stmts[1] != "DROP TABLE b" {
+		t.Fatalf("unexpected statement %q", stmts[1])
+	}
+}
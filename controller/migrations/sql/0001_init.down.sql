@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS extensions;
+DROP TABLE IF EXISTS service_keys;
+DROP TABLE IF EXISTS roles;
+DROP TABLE IF EXISTS accounts;
+DROP TABLE IF EXISTS events;
+DROP TABLE IF EXISTS config;
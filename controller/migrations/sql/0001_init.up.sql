@@ -0,0 +1,53 @@
+CREATE TABLE IF NOT EXISTS config (
+    name VARCHAR(191) NOT NULL,
+    value TEXT NOT NULL,
+    PRIMARY KEY (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS events (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    type VARCHAR(64) NOT NULL,
+    time DATETIME(6) NOT NULL,
+    message TEXT NOT NULL,
+    username VARCHAR(191) NOT NULL DEFAULT '',
+    tags TEXT NOT NULL,
+    PRIMARY KEY (id),
+    KEY idx_events_time (time),
+    KEY idx_events_type (type)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS accounts (
+    id VARCHAR(64) NOT NULL,
+    username VARCHAR(191) NOT NULL,
+    first_name VARCHAR(191) NOT NULL DEFAULT '',
+    last_name VARCHAR(191) NOT NULL DEFAULT '',
+    password VARCHAR(255) NOT NULL DEFAULT '',
+    roles TEXT NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uniq_accounts_username (username)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(191) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    rules TEXT NOT NULL,
+    PRIMARY KEY (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS service_keys (
+    `key` VARCHAR(191) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    PRIMARY KEY (`key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS extensions (
+    id VARCHAR(64) NOT NULL,
+    name VARCHAR(191) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    url VARCHAR(1024) NOT NULL,
+    service_key VARCHAR(128) NOT NULL,
+    events TEXT NOT NULL,
+    hooks TEXT NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uniq_extensions_name (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
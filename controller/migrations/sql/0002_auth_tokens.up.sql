@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS auth_tokens (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    username VARCHAR(191) NOT NULL,
+    token VARCHAR(255) NOT NULL,
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    created_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uniq_auth_tokens_token (token),
+    KEY idx_auth_tokens_username (username)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS password_history (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    username VARCHAR(191) NOT NULL,
+    password VARCHAR(255) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (id),
+    KEY idx_password_history_username (username, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
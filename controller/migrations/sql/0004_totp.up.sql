@@ -0,0 +1,4 @@
+ALTER TABLE accounts
+    ADD COLUMN totp_secret VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN totp_enabled TINYINT(1) NOT NULL DEFAULT 0,
+    ADD COLUMN recovery_codes VARCHAR(2048) NOT NULL DEFAULT '';
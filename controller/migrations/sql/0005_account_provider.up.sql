@@ -0,0 +1,4 @@
+ALTER TABLE accounts ADD COLUMN provider VARCHAR(64) NOT NULL DEFAULT '';
+-- accounts with a local password were created for the builtin provider.
+-- The others were auto-created by ldap and are bound at their next login.
+UPDATE accounts SET provider = 'builtin' WHERE password <> '';
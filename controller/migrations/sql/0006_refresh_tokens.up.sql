@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id VARCHAR(64) NOT NULL,
+    username VARCHAR(191) NOT NULL,
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    expires_at DATETIME(6) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (id),
+    KEY idx_refresh_tokens_username (username),
+    KEY idx_refresh_tokens_expires_at (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
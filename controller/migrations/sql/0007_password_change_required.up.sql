@@ -0,0 +1 @@
+ALTER TABLE accounts ADD COLUMN password_change_required TINYINT(1) NOT NULL DEFAULT 0;
@@ -0,0 +1,73 @@
+// Package response writes the json bodies of the api. Every error is
+// sent in the same envelope so clients can handle them in one place:
+//
+//	{"error": {"code": "account_not_found", "message": "account not found",
+//	  "localized_message": "账户不存在", "request_id": "..."}}
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nicle-lin/lillian/controller/middleware/requestid"
+)
+
+// FieldError points at an invalid field of the request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is the body of every failed api request. Code is stable and
+// meant for programs, Message is english and LocalizedMessage is shown
+// in the ui.
+type Error struct {
+	Code             string       `json:"code"`
+	Message          string       `json:"message"`
+	LocalizedMessage string       `json:"localized_message,omitempty"`
+	RequestID        string       `json:"request_id,omitempty"`
+	Fields           []FieldError `json:"fields,omitempty"`
+}
+
+type envelope struct {
+	Error *Error `json:"error"`
+}
+
+// JSON writes v with the status. A content type set by the caller, e.g.
+// application/jwk-set+json, is kept.
+func JSON(w http.ResponseWriter, status int, v interface{}) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("error writing response: %s", err)
+	}
+}
+
+// WriteError writes e in the error envelope, tagged with the request id
+func WriteError(w http.ResponseWriter, r *http.Request, status int, e *Error) {
+	e.RequestID = requestid.FromRequest(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	JSON(w, status, envelope{Error: e})
+}
+
+// Unauthorized is written when the request carries no valid credentials
+func Unauthorized(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, r, http.StatusUnauthorized, &Error{
+		Code:             "unauthorized",
+		Message:          "authentication required",
+		LocalizedMessage: "没有认证",
+	})
+}
+
+// Forbidden is written when the user may not do what was asked
+func Forbidden(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, r, http.StatusForbidden, &Error{
+		Code:             "forbidden",
+		Message:          "access denied",
+		LocalizedMessage: "没有权限",
+	})
+}
@@ -0,0 +1,44 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nicle-lin/lillian/controller/middleware/requestid"
+)
+
+func TestWriteError(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/v1/accounts/foo", nil)
+	req.Header.Set(requestid.Header, "abc-123")
+	res := httptest.NewRecorder()
+
+	requestid.NewRequestID().Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, http.StatusBadRequest, &Error{
+			Code:    "invalid_account",
+			Message: "invalid account",
+			Fields:  []FieldError{{Field: "username", Message: "is required"}},
+		})
+	})).ServeHTTP(res, req)
+
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400; got %d", res.Code)
+	}
+	if v := res.Header().Get("Content-Type"); v != "application/json" {
+		t.Fatalf("expected json; got %q", v)
+	}
+
+	var body struct {
+		Error Error `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Code != "invalid_account" || body.Error.RequestID != "abc-123" {
+		t.Fatalf("expected code and request id; got %+v", body.Error)
+	}
+	if len(body.Error.Fields) != 1 || body.Error.Fields[0].Field != "username" {
+		t.Fatalf("expected username field error; got %+v", body.Error.Fields)
+	}
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/nicle-lin/lillian/controller/manager"
+	"github.com/nicle-lin/lillian/helper/auth"
+	"golang.org/x/term"
+)
+
+const initialAdminUsername = "admin"
+
+// generatedPasswordPolicy is strict enough to satisfy any configured
+// policy with a minimum length up to 24 characters
+var generatedPasswordPolicy = auth.PasswordPolicy{
+	MinLength:     24,
+	RequireUpper:  true,
+	RequireLower:  true,
+	RequireDigit:  true,
+	RequireSymbol: true,
+}
+
+// randomPassword returns a password with 18 bytes of entropy that
+// contains every character class
+func randomPassword() (string, error) {
+	b := make([]byte, 18)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		password := base64.RawURLEncoding.EncodeToString(b)
+		if generatedPasswordPolicy.Validate(password) == nil {
+			return password, nil
+		}
+	}
+}
+
+// accountsManager returns a manager working directly against the
+// configured mysql store, for the accounts commands
+func accountsManager(c *cli.Context) manager.Manager {
+	if err := loadConfig(c); err != nil {
+		log.Fatal(err)
+	}
+	db := mysqlSession()
+	authenticator, err := newAuthenticator()
+	if err != nil {
+		log.Fatal(err)
+	}
+	m, err := manager.NewManager(nil, db, nil, true, authenticator, securityConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+	return m
+}
+
+// closeManager flushes the events logged by a command
+func closeManager(m manager.Manager) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := m.Close(ctx); err != nil {
+		log.Error(err)
+	}
+}
+
+func splitRoles(roles string) []string {
+	result := []string{}
+	for _, r := range strings.Split(roles, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// readPassword returns the --password flag or else reads the password
+// from stdin, prompting without echo on a terminal, so it does not have
+// to show up in the process list or the shell history. It returns an
+// empty string when nothing was entered.
+func readPassword(c *cli.Context, prompt string) string {
+	if password := c.String("password"); password != "" {
+		return password
+	}
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return string(b)
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		log.Fatal(err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+func requireUsername(c *cli.Context) string {
+	username := c.String("username")
+	if username == "" {
+		log.Fatal("--username is required")
+	}
+	return username
+}
+
+// AccountsCreate creates an account with the password from --password
+// or stdin; without one a random password is generated and printed
+func AccountsCreate(c *cli.Context) {
+	username := requireUsername(c)
+	m := accountsManager(c)
+	defer closeManager(m)
+
+	if _, err := m.Account(username); err == nil {
+		log.Fatal(manager.ErrAccountExists)
+	} else if err != manager.ErrAccountDoesNotExist {
+		log.Fatal(err)
+	}
+
+	password := readPassword(c, "password (empty for a random one): ")
+	generated := password == ""
+	if generated {
+		p, err := randomPassword()
+		if err != nil {
+			log.Fatal(err)
+		}
+		password = p
+	}
+
+	acct := &auth.Account{
+		Username:  username,
+		FirstName: c.String("first-name"),
+		LastName:  c.String("last-name"),
+		Password:  password,
+		Roles:     splitRoles(c.String("roles")),
+	}
+	if err := m.CreateAccount(acct); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("created account %s\n", username)
+	if generated {
+		fmt.Printf("password: %s\n", password)
+	}
+}
+
+// AccountsList prints all accounts
+func AccountsList(c *cli.Context) {
+	m := accountsManager(c)
+	defer closeManager(m)
+
+	accounts, err := m.Accounts()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "USERNAME\tNAME\tPROVIDER\tROLES")
+	for _, acct := range accounts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", acct.Username, strings.TrimSpace(acct.FirstName+" "+acct.LastName),
+			acct.Provider, strings.Join(acct.Roles, ","))
+	}
+	w.Flush()
+}
+
+// AccountsSetPassword replaces the password of an account
+func AccountsSetPassword(c *cli.Context) {
+	username := requireUsername(c)
+	password := readPassword(c, "new password: ")
+	if password == "" {
+		log.Fatal("a password is required on stdin or with --password")
+	}
+
+	m := accountsManager(c)
+	defer closeManager(m)
+
+	if err := m.ChangePassword(username, password); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("updated password for %s\n", username)
+}
+
+// AccountsSetRoles replaces the roles of an account
+func AccountsSetRoles(c *cli.Context) {
+	username := requireUsername(c)
+	m := accountsManager(c)
+	defer closeManager(m)
+
+	acct, err := m.Account(username)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// keep the stored hash
+	acct.Password = ""
+	acct.Roles = splitRoles(c.String("roles"))
+	if err := m.SaveAccount(acct); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("updated roles for %s: %s\n", username, strings.Join(acct.Roles, ","))
+}
+
+// AccountsDelete removes an account and its tokens
+func AccountsDelete(c *cli.Context) {
+	username := requireUsername(c)
+	m := accountsManager(c)
+	defer closeManager(m)
+
+	acct, err := m.Account(username)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := m.DeleteAccount(acct); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("deleted account %s\n", username)
+}
+
+// bootstrapAdmin creates the first admin account when no accounts exist,
+// printing its random password once so a fresh install can log in. The
+// password has to be changed at the first login. When several instances
+// start together, the unique username lets only one of them create it.
+func bootstrapAdmin(m manager.Manager) error {
+	accounts, err := m.Accounts()
+	if err != nil {
+		return err
+	}
+	if len(accounts) > 0 {
+		return nil
+	}
+
+	password, err := randomPassword()
+	if err != nil {
+		return err
+	}
+	acct := &auth.Account{
+		Username:               initialAdminUsername,
+		Password:               password,
+		Roles:                  []string{"admin"},
+		PasswordChangeRequired: true,
+	}
+	if err := m.CreateAccount(acct); err != nil {
+		if err == manager.ErrAccountExists {
+			log.Infof("initial admin account was created by another instance")
+			return nil
+		}
+		return err
+	}
+
+	fmt.Printf("\n  created initial admin account\n  username: %s\n  password: %s\n  this password is only shown once and has to be changed at the first login\n\n",
+		initialAdminUsername, password)
+	return nil
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/nicle-lin/lillian/controller/manager"
+	"github.com/nicle-lin/lillian/helper/auth"
+)
+
+// bootstrapManager starts without accounts; created is what another
+// instance created in the meantime, if anything. Other methods are not
+// used by the tests and panic.
+type bootstrapManager struct {
+	manager.Manager
+	created  *auth.Account
+	accounts []*auth.Account
+}
+
+func (m *bootstrapManager) Accounts() ([]*auth.Account, error) {
+	return m.accounts, nil
+}
+
+func (m *bootstrapManager) CreateAccount(acct *auth.Account) error {
+	if m.created != nil {
+		return manager.ErrAccountExists
+	}
+	m.created = acct
+	return nil
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	m := &bootstrapManager{}
+	if err := bootstrapAdmin(m); err != nil {
+		t.Fatal(err)
+	}
+	if m.created == nil || m.created.Username != initialAdminUsername {
+		t.Fatalf("expected the admin account to be created; received %+v", m.created)
+	}
+	if !m.created.PasswordChangeRequired {
+		t.Fatal("expected the generated password to require a change")
+	}
+
+	// another instance created it between the check and the insert
+	if err := bootstrapAdmin(m); err != nil {
+		t.Fatalf("expected a concurrent create to be ignored; received %s", err)
+	}
+}
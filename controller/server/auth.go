@@ -0,0 +1,124 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/nicle-lin/lillian/helper/auth"
+	"github.com/nicle-lin/lillian/helper/auth/builtin"
+	"github.com/nicle-lin/lillian/helper/auth/chain"
+	"github.com/nicle-lin/lillian/helper/auth/ldap"
+	"github.com/nicle-lin/lillian/helper/auth/oidc"
+)
+
+// newAuthenticator returns the password authenticator for [auth]
+// providers. Several providers are tried in the configured order.
+func newAuthenticator() (auth.Authenticator, error) {
+	providers := []auth.Authenticator{}
+	for _, name := range cfg.Section("auth").Key("providers").Strings(",") {
+		switch name {
+		case "builtin":
+			providers = append(providers, builtin.NewAuthenticator("defaultlillian"))
+		case "ldap":
+			a, err := ldapAuthenticator()
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, a)
+		}
+	}
+
+	if len(providers) == 1 {
+		return providers[0], nil
+	}
+	return chain.NewAuthenticator(providers...), nil
+}
+
+func ldapAuthenticator() (*ldap.LdapAuthenticator, error) {
+	// validated when the config was loaded
+	groupRoles, _ := parseRoleMapping(GetKeyValueString("ldap", "groupRoles"))
+
+	config := ldap.Config{
+		Server:             GetKeyValueString("ldap", "server"),
+		Port:               GetKeyValueInt("ldap", "port"),
+		Security:           GetKeyValueString("ldap", "security"),
+		InsecureSkipVerify: GetKeyValueBool("ldap", "insecureSkipVerify"),
+		BaseDN:             GetKeyValueString("ldap", "baseDN"),
+		BindDN:             GetKeyValueString("ldap", "bindDN"),
+		BindPassword:       GetKeyValueString("ldap", "bindPassword"),
+		UserSearchBase:     GetKeyValueString("ldap", "userSearchBase"),
+		UserFilter:         GetKeyValueString("ldap", "userFilter"),
+		GroupSearchBase:    GetKeyValueString("ldap", "groupSearchBase"),
+		GroupFilter:        GetKeyValueString("ldap", "groupFilter"),
+		GroupAttribute:     GetKeyValueString("ldap", "groupAttribute"),
+		GroupRoles:         groupRoles,
+		DefaultAccessLevel: GetKeyValueString("ldap", "defaultAccessLevel"),
+		AutocreateUsers:    GetKeyValueBool("ldap", "autocreateUsers"),
+	}
+
+	if path := GetKeyValueString("ldap", "caCertPath"); path != "" {
+		ca, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("无法读取 ldap CA 证书 %s: %s", path, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("无效的 ldap CA 证书 %s", path)
+		}
+		config.TLSConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return ldap.NewAuthenticator(config)
+}
+
+// parseRoleMapping parses "group=role,other=role" into a map from the
+// provider's group names to lillian roles
+func parseRoleMapping(s string) (map[string]string, error) {
+	return parsePairs(s, "角色映射", "group=role")
+}
+
+// parsePairs parses a comma separated list of name=value pairs
+func parsePairs(s, what, format string) (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("无效的%s %q, 格式为 %s", what, pair, format)
+		}
+		mapping[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return mapping, nil
+}
+
+// oidcAuthenticator returns the OpenID Connect login configured in the
+// [oidc] section, or nil when it is disabled
+func oidcAuthenticator() *oidc.OidcAuthenticator {
+	if !GetKeyValueBool("oidc", "enabled") {
+		return nil
+	}
+	// validated when the config was loaded
+	roleMapping, _ := parseRoleMapping(GetKeyValueString("oidc", "roleMapping"))
+
+	return oidc.NewAuthenticator(oidc.Config{
+		IssuerURL:       GetKeyValueString("oidc", "issuerURL"),
+		ClientID:        GetKeyValueString("oidc", "clientID"),
+		ClientSecret:    GetKeyValueString("oidc", "clientSecret"),
+		RedirectURL:     GetKeyValueString("oidc", "redirectURL"),
+		Scopes:          cfg.Section("oidc").Key("scopes").Strings(","),
+		UsernameClaim:   GetKeyValueString("oidc", "usernameClaim"),
+		RolesClaim:      GetKeyValueString("oidc", "rolesClaim"),
+		RoleMapping:     roleMapping,
+		DefaultRoles:    cfg.Section("oidc").Key("defaultRoles").Strings(","),
+		AutocreateUsers: GetKeyValueBool("oidc", "autocreateUsers"),
+		AllowedDomains:  cfg.Section("oidc").Key("allowedDomains").Strings(","),
+		AllowedGroups:   cfg.Section("oidc").Key("allowedGroups").Strings(","),
+		PostLoginURL:    GetKeyValueString("oidc", "postLoginURL"),
+	})
+}
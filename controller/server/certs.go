@@ -0,0 +1,207 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/nicle-lin/lillian/helper/tlsutils"
+)
+
+const (
+	caName       = "ca"
+	defaultOrg   = "lillian"
+	defaultRSA   = 2048
+	defaultECDSA = 256
+)
+
+func keyOptions(c *cli.Context) (string, int) {
+	keyType := c.String("key-type")
+	bits := c.Int("bits")
+	if bits == 0 {
+		bits = defaultRSA
+		if keyType == tlsutils.KeyTypeECDSA {
+			bits = defaultECDSA
+		}
+	}
+	return keyType, bits
+}
+
+func readCA(dir string) ([]byte, []byte, error) {
+	caCert, err := ioutil.ReadFile(filepath.Join(dir, caName+".pem"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("无法读取 CA 证书, 请先运行 certs init-ca: %s", err)
+	}
+	caKey, err := ioutil.ReadFile(filepath.Join(dir, caName+"-key.pem"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("无法读取 CA 密钥, 请先运行 certs init-ca: %s", err)
+	}
+	return caCert, caKey, nil
+}
+
+// CertsInitCA creates a new certificate authority in the certs directory
+func CertsInitCA(c *cli.Context) {
+	dir := c.String("dir")
+	if _, err := os.Stat(filepath.Join(dir, caName+".pem")); err == nil && !c.Bool("force") {
+		log.Fatalf("CA already exists in %s; use --force to replace it", dir)
+	}
+
+	keyType, bits := keyOptions(c)
+	cert, key, err := tlsutils.GenerateCA(tlsutils.CertOptions{
+		Org:        c.String("org"),
+		CommonName: c.String("org") + " CA",
+		KeyType:    keyType,
+		Bits:       bits,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	certPath, keyPath, err := tlsutils.WriteCertificate(dir, caName, cert, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("created CA: cert=%s key=%s", certPath, keyPath)
+}
+
+// CertsServer creates a server certificate for the given hosts signed by
+// the CA in the certs directory
+func CertsServer(c *cli.Context) {
+	hosts := c.StringSlice("host")
+	if len(hosts) == 0 {
+		log.Fatal("at least one --host is required")
+	}
+
+	dir := c.String("dir")
+	caCert, caKey, err := readCA(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keyType, bits := keyOptions(c)
+	cert, key, err := tlsutils.GenerateSignedCert(tlsutils.CertOptions{
+		Org:        c.String("org"),
+		CommonName: hosts[0],
+		Hosts:      hosts,
+		KeyType:    keyType,
+		Bits:       bits,
+	}, caCert, caKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	certPath, keyPath, err := tlsutils.WriteCertificate(dir, c.String("name"), cert, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("created server certificate: hosts=%v cert=%s key=%s", hosts, certPath, keyPath)
+}
+
+// CertsClient creates a client certificate signed by the CA in the certs
+// directory. The common name is the account used for service calls.
+func CertsClient(c *cli.Context) {
+	cn := c.String("cn")
+	if cn == "" {
+		log.Fatal("--cn is required")
+	}
+
+	dir := c.String("dir")
+	caCert, caKey, err := readCA(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keyType, bits := keyOptions(c)
+	cert, key, err := tlsutils.GenerateSignedCert(tlsutils.CertOptions{
+		Org:        c.String("org"),
+		CommonName: cn,
+		Client:     true,
+		KeyType:    keyType,
+		Bits:       bits,
+	}, caCert, caKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	certPath, keyPath, err := tlsutils.WriteCertificate(dir, cn, cert, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("created client certificate: cn=%s cert=%s key=%s", cn, certPath, keyPath)
+}
+
+// ensureCertificates generates a self-signed CA and server certificate
+// when TLS is configured but neither the certificate nor the key exist,
+// so a fresh install can start with TLS enabled
+func ensureCertificates(caPath, certPath, keyPath, listenAddr string) error {
+	if certPath == "" || keyPath == "" {
+		return nil
+	}
+	_, certErr := os.Stat(certPath)
+	_, keyErr := os.Stat(keyPath)
+	if !os.IsNotExist(certErr) || !os.IsNotExist(keyErr) {
+		return nil
+	}
+
+	if caPath != "" {
+		if _, err := os.Stat(caPath); err == nil {
+			return fmt.Errorf("CA %s exists but server certificate %s is missing; create it with certs server", caPath, certPath)
+		}
+	}
+
+	caCert, caKey, err := tlsutils.GenerateCA(tlsutils.CertOptions{
+		Org:        defaultOrg,
+		CommonName: defaultOrg + " self-signed CA",
+		KeyType:    tlsutils.KeyTypeECDSA,
+		Bits:       defaultECDSA,
+	})
+	if err != nil {
+		return err
+	}
+
+	hosts := []string{"localhost", "127.0.0.1"}
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname)
+	}
+	if host, _, err := net.SplitHostPort(listenAddr); err == nil && host != "" && host != "0.0.0.0" && host != "::" {
+		hosts = append(hosts, host)
+	}
+
+	cert, key, err := tlsutils.GenerateSignedCert(tlsutils.CertOptions{
+		Org:        defaultOrg,
+		CommonName: hosts[len(hosts)-1],
+		Hosts:      hosts,
+		KeyType:    tlsutils.KeyTypeECDSA,
+		Bits:       defaultECDSA,
+	}, caCert, caKey)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range []struct {
+		path string
+		data []byte
+		mode os.FileMode
+	}{
+		{certPath, cert, 0644},
+		{keyPath, key, 0600},
+		{caPath, caCert, 0644},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(f.path, f.data, f.mode); err != nil {
+			return err
+		}
+	}
+
+	log.Warnf("generated self-signed TLS certificate: cert=%s key=%s hosts=%v", certPath, keyPath, hosts)
+	return nil
+}
@@ -0,0 +1,405 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/go-ini/ini"
+)
+
+const (
+	defaultConfigPath = "config/config.ini"
+	envPrefix         = "LILLIAN_"
+)
+
+// defaultConfig lists every supported setting with its default value.
+// Settings are layered: these defaults, then the config file, then
+// LILLIAN_<SECTION>_<KEY> environment variables, then command line flags.
+const defaultConfig = `
+[mysql]
+host = 127.0.0.1
+port = 3306
+user = root
+password =
+dbname = lillian
+charset = utf8
+autoMigrate = false
+
+[redis]
+host = 127.0.0.1
+port = 6379
+password =
+dbname = 0
+
+[session]
+host = 127.0.0.1
+port = 6379
+password =
+gclifetime = 3600
+cookiename = lilliansessionid
+maxpoolsize = 100
+cookieSecure = false
+cookieSameSite = lax
+cookieDomain =
+
+[app]
+host = 0.0.0.0:5525
+tlsCACertPath =
+tlsCertPath =
+tlsKeyPath =
+tlsVerifyClients = false
+tlsAllowInsecure = false
+authWhitelistCIDRs =
+shutdownTimeout = 30
+frontendDir =
+
+[metrics]
+token =
+
+[cors]
+allowedOrigins =
+allowedMethods = GET,POST,PUT,DELETE
+allowedHeaders = Content-Type,Authorization,X-Access-Token,X-XSRF-TOKEN
+exposedHeaders = X-Lillian-OTP
+allowCredentials = false
+maxAge = 600
+
+[security]
+passwordMinLength = 8
+passwordRequireUpper = false
+passwordRequireLower = true
+passwordRequireDigit = true
+passwordRequireSymbol = false
+passwordHistory = 5
+maxFailedLogins = 5
+maxFailedLoginsPerIP = 20
+lockoutDuration = 900
+totpIssuer = lillian
+totpRequiredRoles =
+
+[auth]
+providers = builtin
+
+[ldap]
+server =
+port = 389
+security = none
+insecureSkipVerify = false
+caCertPath =
+baseDN =
+bindDN =
+bindPassword =
+userSearchBase =
+userFilter = (uid={username})
+groupSearchBase =
+groupFilter = (member={dn})
+groupAttribute = cn
+groupRoles =
+defaultAccessLevel =
+autocreateUsers = true
+
+[oidc]
+enabled = false
+issuerURL =
+clientID =
+clientSecret =
+redirectURL =
+scopes = openid,profile,email
+usernameClaim = email
+rolesClaim =
+roleMapping =
+defaultRoles =
+autocreateUsers = false
+allowedDomains =
+allowedGroups =
+postLoginURL = /
+
+[jwt]
+enabled = false
+algorithm = EdDSA
+keyID = 1
+keyPath = config/jwt-key.pem
+secret =
+previousKeys =
+previousSecrets =
+issuer = lillian
+accessTTL = 900
+refreshTTL = 604800
+`
+
+// flagOverrides maps command line flags, including their short names,
+// to the setting they override
+var flagOverrides = map[string][2]string{
+	"listen,l": {"app", "host"},
+}
+
+var (
+	cfg *ini.File
+)
+
+// loadConfig builds the configuration for a command from all layers and
+// validates it. It must be called before any GetKeyValue* lookup.
+func loadConfig(c *cli.Context) error {
+	path := c.GlobalString("config")
+	f, err := loadConfigFile(path, path != "")
+	if err != nil {
+		return err
+	}
+
+	applyEnv(f, os.LookupEnv)
+
+	for flag, setting := range flagOverrides {
+		names := strings.Split(flag, ",")
+		for _, name := range names {
+			if c.IsSet(name) {
+				f.Section(setting[0]).Key(setting[1]).SetValue(c.String(names[0]))
+				break
+			}
+		}
+	}
+
+	if err := validateConfig(f); err != nil {
+		return err
+	}
+
+	cfg = f
+	return nil
+}
+
+// loadConfigFile loads the defaults overlaid with the config file at path.
+// Without an explicit path the default location is optional, so the
+// server can run from environment variables only.
+func loadConfigFile(path string, explicit bool) (*ini.File, error) {
+	f, err := ini.Load([]byte(defaultConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	if path == "" {
+		path = defaultConfigPath
+	}
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return f, nil
+		}
+		return nil, fmt.Errorf("无法读取配置文件 %s: %s", path, err)
+	}
+
+	if err := f.Append(path); err != nil {
+		return nil, fmt.Errorf("无法解析配置文件 %s: %s", path, err)
+	}
+	return f, nil
+}
+
+// applyEnv overrides every known setting with LILLIAN_<SECTION>_<KEY>,
+// e.g. LILLIAN_MYSQL_HOST or LILLIAN_APP_TLSCERTPATH
+func applyEnv(f *ini.File, lookup func(string) (string, bool)) {
+	for _, section := range f.Sections() {
+		if section.Name() == ini.DEFAULT_SECTION {
+			continue
+		}
+		for _, key := range section.Keys() {
+			name := envPrefix + strings.ToUpper(section.Name()) + "_" + strings.ToUpper(key.Name())
+			if v, ok := lookup(name); ok {
+				key.SetValue(v)
+			}
+		}
+	}
+}
+
+func validateConfig(f *ini.File) error {
+	errs := []string{}
+	invalid := func(section, key, format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf("[%s] %s: %s", section, key, fmt.Sprintf(format, args...)))
+	}
+
+	if _, _, err := net.SplitHostPort(f.Section("app").Key("host").String()); err != nil {
+		invalid("app", "host", "%s", err)
+	}
+
+	// passwords are optional, a local mysql or redis may not need one
+	for _, setting := range [][2]string{{"mysql", "host"}, {"mysql", "port"}, {"mysql", "user"}, {"mysql", "dbname"},
+		{"redis", "host"}, {"redis", "port"}, {"session", "host"}, {"session", "port"}} {
+		if f.Section(setting[0]).Key(setting[1]).String() == "" {
+			invalid(setting[0], setting[1], "需要配置")
+		}
+	}
+
+	for _, s := range []string{"mysql", "redis", "session"} {
+		if port := f.Section(s).Key("port").String(); port != "" {
+			if _, err := f.Section(s).Key("port").Int(); err != nil {
+				invalid(s, "port", "需要是数字, 当前为 %q", port)
+			}
+		}
+	}
+
+	for _, setting := range [][2]string{{"session", "gclifetime"}, {"session", "maxpoolsize"}, {"app", "shutdownTimeout"},
+		{"security", "lockoutDuration"}, {"jwt", "accessTTL"}, {"jwt", "refreshTTL"}} {
+		section, k := setting[0], setting[1]
+		if v := f.Section(section).Key(k).String(); v != "" {
+			if i, err := f.Section(section).Key(k).Int(); err != nil || i <= 0 {
+				invalid(section, k, "需要是正整数, 当前为 %q", v)
+			}
+		}
+	}
+
+	cert := f.Section("app").Key("tlsCertPath").String()
+	key := f.Section("app").Key("tlsKeyPath").String()
+	if (cert == "") != (key == "") {
+		invalid("app", "tlsCertPath", "tlsCertPath 和 tlsKeyPath 需要同时配置")
+	}
+
+	for _, k := range []string{"passwordMinLength", "passwordHistory", "maxFailedLogins", "maxFailedLoginsPerIP"} {
+		if i, err := f.Section("security").Key(k).Int(); err != nil || i < 0 {
+			invalid("security", k, "需要是非负整数")
+		}
+	}
+
+	for _, setting := range [][2]string{{"app", "tlsVerifyClients"}, {"app", "tlsAllowInsecure"}, {"mysql", "autoMigrate"},
+		{"security", "passwordRequireUpper"}, {"security", "passwordRequireLower"}, {"security", "passwordRequireDigit"},
+		{"security", "passwordRequireSymbol"}, {"oidc", "enabled"}, {"oidc", "autocreateUsers"},
+		{"ldap", "insecureSkipVerify"}, {"ldap", "autocreateUsers"}, {"jwt", "enabled"}, {"session", "cookieSecure"},
+		{"cors", "allowCredentials"}} {
+		if _, err := f.Section(setting[0]).Key(setting[1]).Bool(); err != nil {
+			invalid(setting[0], setting[1], "需要是 true 或 false")
+		}
+	}
+	if verify, _ := f.Section("app").Key("tlsVerifyClients").Bool(); verify {
+		if cert == "" || f.Section("app").Key("tlsCACertPath").String() == "" {
+			invalid("app", "tlsVerifyClients", "需要配置 tlsCACertPath, tlsCertPath 和 tlsKeyPath")
+		}
+	}
+
+	if _, err := parseSameSite(f.Section("session").Key("cookieSameSite").String()); err != nil {
+		invalid("session", "cookieSameSite", "%s", err)
+	} else if f.Section("session").Key("cookieSameSite").String() == "none" {
+		if secure, _ := f.Section("session").Key("cookieSecure").Bool(); !secure {
+			invalid("session", "cookieSameSite", "none 需要 cookieSecure = true")
+		}
+	}
+
+	if dir := f.Section("app").Key("frontendDir").String(); dir != "" {
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			invalid("app", "frontendDir", "目录 %q 不存在", dir)
+		}
+	}
+
+	for _, c := range f.Section("app").Key("authWhitelistCIDRs").Strings(",") {
+		if _, _, err := net.ParseCIDR(c); err != nil {
+			invalid("app", "authWhitelistCIDRs", "%s", err)
+		}
+	}
+
+	for _, o := range f.Section("cors").Key("allowedOrigins").Strings(",") {
+		if o == "*" {
+			if credentials, _ := f.Section("cors").Key("allowCredentials").Bool(); credentials {
+				invalid("cors", "allowedOrigins", "* 不能和 allowCredentials = true 一起使用")
+			}
+			continue
+		}
+		if u, err := url.Parse(o); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			invalid("cors", "allowedOrigins", "无效的来源 %q, 格式为 https://host[:port]", o)
+		}
+	}
+	if v := f.Section("cors").Key("maxAge").String(); v != "" {
+		if i, err := f.Section("cors").Key("maxAge").Int(); err != nil || i < 0 {
+			invalid("cors", "maxAge", "需要是非负整数")
+		}
+	}
+
+	providers := f.Section("auth").Key("providers").Strings(",")
+	if len(providers) == 0 {
+		invalid("auth", "providers", "至少需要一个认证方式")
+	}
+	seen := map[string]bool{}
+	for _, p := range providers {
+		if p != "builtin" && p != "ldap" {
+			invalid("auth", "providers", "不支持的认证方式 %q, 需要是 builtin 或 ldap", p)
+		}
+		if seen[p] {
+			invalid("auth", "providers", "重复的认证方式 %q", p)
+		}
+		seen[p] = true
+	}
+	if seen["ldap"] {
+		if f.Section("ldap").Key("server").String() == "" {
+			invalid("ldap", "server", "使用 ldap 认证时需要配置")
+		}
+		if port, err := f.Section("ldap").Key("port").Int(); err != nil || port <= 0 || port > 65535 {
+			invalid("ldap", "port", "无效的端口")
+		}
+		switch f.Section("ldap").Key("security").String() {
+		case "none", "starttls", "ldaps":
+		default:
+			invalid("ldap", "security", "需要是 none, starttls 或 ldaps")
+		}
+		if f.Section("ldap").Key("groupSearchBase").String() != "" && f.Section("ldap").Key("bindDN").String() == "" {
+			invalid("ldap", "groupSearchBase", "查询用户组需要配置 bindDN")
+		}
+		if _, err := parseRoleMapping(f.Section("ldap").Key("groupRoles").String()); err != nil {
+			invalid("ldap", "groupRoles", "%s", err)
+		}
+	}
+
+	if enabled, _ := f.Section("oidc").Key("enabled").Bool(); enabled {
+		for _, k := range []string{"issuerURL", "clientID", "redirectURL"} {
+			if f.Section("oidc").Key(k).String() == "" {
+				invalid("oidc", k, "启用 OIDC 时需要配置")
+			}
+		}
+		if _, err := parseRoleMapping(f.Section("oidc").Key("roleMapping").String()); err != nil {
+			invalid("oidc", "roleMapping", "%s", err)
+		}
+		if f.Section("oidc").Key("allowedGroups").String() != "" && f.Section("oidc").Key("rolesClaim").String() == "" {
+			invalid("oidc", "allowedGroups", "需要配置 rolesClaim")
+		}
+	}
+
+	if enabled, _ := f.Section("jwt").Key("enabled").Bool(); enabled {
+		jwtSection := f.Section("jwt")
+		switch jwtSection.Key("algorithm").String() {
+		case "HS256":
+			if len(jwtSection.Key("secret").String()) < 32 {
+				invalid("jwt", "secret", "HS256 需要至少 32 字节的密钥")
+			}
+		case "RS256", "EdDSA":
+			if jwtSection.Key("keyPath").String() == "" {
+				invalid("jwt", "keyPath", "使用 %s 时需要配置", jwtSection.Key("algorithm").String())
+			}
+		default:
+			invalid("jwt", "algorithm", "需要是 HS256, RS256 或 EdDSA")
+		}
+		if jwtSection.Key("keyID").String() == "" {
+			invalid("jwt", "keyID", "启用 JWT 时需要配置")
+		}
+		if _, err := parsePairs(jwtSection.Key("previousKeys").String(), "密钥", "keyID=path"); err != nil {
+			invalid("jwt", "previousKeys", "%s", err)
+		}
+		if _, err := parsePairs(jwtSection.Key("previousSecrets").String(), "密钥", "keyID=secret"); err != nil {
+			invalid("jwt", "previousSecrets", "%s", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("无效的配置:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// parseSameSite returns the SameSite attribute for lax, strict or none
+func parseSameSite(s string) (http.SameSite, error) {
+	switch s {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("需要是 lax, strict 或 none, 当前为 %q", s)
+}
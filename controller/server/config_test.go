@@ -0,0 +1,123 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	f, err := loadConfigFile(filepath.Join(os.TempDir(), "lillian-missing.ini"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if host := f.Section("app").Key("host").String(); host != "0.0.0.0:5525" {
+		t.Fatalf("expected default host 0.0.0.0:5525; received %s", host)
+	}
+}
+
+func TestLoadConfigMissingExplicit(t *testing.T) {
+	if _, err := loadConfigFile(filepath.Join(os.TempDir(), "lillian-missing.ini"), true); err == nil {
+		t.Fatal("expected error for missing config file")
+	}
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lillian-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.ini")
+	if err := ioutil.WriteFile(path, []byte("[mysql]\nhost = db\nuser = lillian\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := loadConfigFile(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"LILLIAN_MYSQL_USER":      "crm",
+		"LILLIAN_APP_TLSCERTPATH": "/certs/cert.pem",
+	}
+	applyEnv(f, func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	})
+
+	if v := f.Section("mysql").Key("host").String(); v != "db" {
+		t.Fatalf("expected host from file; received %s", v)
+	}
+	if v := f.Section("mysql").Key("user").String(); v != "crm" {
+		t.Fatalf("expected user from env; received %s", v)
+	}
+	if v := f.Section("mysql").Key("port").String(); v != "3306" {
+		t.Fatalf("expected default port; received %s", v)
+	}
+	if v := f.Section("app").Key("tlsCertPath").String(); v != "/certs/cert.pem" {
+		t.Fatalf("expected tls cert from env; received %s", v)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	f, err := loadConfigFile("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateConfig(f); err != nil {
+		t.Fatalf("expected defaults to be valid; received %s", err)
+	}
+
+	f.Section("app").Key("host").SetValue("5525")
+	f.Section("mysql").Key("port").SetValue("abc")
+	f.Section("app").Key("tlsCertPath").SetValue("/certs/cert.pem")
+	f.Section("auth").Key("providers").SetValue("ldap,kerberos")
+	f.Section("oidc").Key("enabled").SetValue("true")
+	f.Section("oidc").Key("roleMapping").SetValue("sales=events:ro,staff")
+	f.Section("oidc").Key("allowedGroups").SetValue("staff")
+	f.Section("jwt").Key("enabled").SetValue("true")
+	f.Section("jwt").Key("algorithm").SetValue("HS256")
+	f.Section("jwt").Key("secret").SetValue("short")
+	f.Section("session").Key("cookieSameSite").SetValue("none")
+	f.Section("cors").Key("allowedOrigins").SetValue("https://ui.example.com,ui.example.com")
+
+	err = validateConfig(f)
+	if err == nil {
+		t.Fatal("expected invalid config")
+	}
+	for _, s := range []string{"[app] host", "[mysql] port", "[app] tlsCertPath", "[auth] providers", "[ldap] server", "[oidc] issuerURL",
+		"[oidc] roleMapping", "[oidc] allowedGroups", "[jwt] secret", "[session] cookieSameSite",
+		"[cors] allowedOrigins"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("expected error to mention %s; received %s", s, err)
+		}
+	}
+}
+
+func TestValidateConfigStores(t *testing.T) {
+	f, err := loadConfigFile("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := f.Section("mysql").Key("password").String(); p != "" {
+		t.Fatalf("expected no default mysql password; received %q", p)
+	}
+
+	f.Section("mysql").Key("host").SetValue("")
+	f.Section("redis").Key("port").SetValue("")
+	err = validateConfig(f)
+	if err == nil {
+		t.Fatal("expected error for missing store settings")
+	}
+	for _, s := range []string{"[mysql] host", "[redis] port"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("expected error to mention %s; received %s", s, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nicle-lin/lillian/helper/auth/jwt"
+)
+
+// jwtIssuer returns the bearer token issuer configured in the [jwt]
+// section, or nil when it is disabled. A missing RS256 or EdDSA key is
+// generated, so every instance sharing the file signs with the same key.
+func jwtIssuer() (*jwt.Issuer, error) {
+	if !GetKeyValueBool("jwt", "enabled") {
+		return nil, nil
+	}
+
+	// validated when the config was loaded
+	alg := GetKeyValueString("jwt", "algorithm")
+	keyID := GetKeyValueString("jwt", "keyID")
+	previousKeys, _ := parsePairs(GetKeyValueString("jwt", "previousKeys"), "密钥", "keyID=path")
+	previousSecrets, _ := parsePairs(GetKeyValueString("jwt", "previousSecrets"), "密钥", "keyID=secret")
+
+	var (
+		signing *jwt.Key
+		err     error
+	)
+	if alg == jwt.HS256 {
+		signing, err = jwt.NewHMACKey(keyID, []byte(GetKeyValueString("jwt", "secret")))
+	} else {
+		signing, err = loadSigningKey(keyID, alg, GetKeyValueString("jwt", "keyPath"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	others := []*jwt.Key{}
+	for id, path := range previousKeys {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("无法读取 JWT 密钥 %s: %s", path, err)
+		}
+		k, err := jwt.ParseKey(id, data)
+		if err != nil {
+			return nil, fmt.Errorf("无效的 JWT 密钥 %s: %s", path, err)
+		}
+		others = append(others, k)
+	}
+	for id, secret := range previousSecrets {
+		k, err := jwt.NewHMACKey(id, []byte(secret))
+		if err != nil {
+			return nil, fmt.Errorf("无效的 JWT 密钥 %s: %s", id, err)
+		}
+		others = append(others, k)
+	}
+
+	keys, err := jwt.NewKeySet(signing, others...)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("issuing jwt bearer tokens: alg=%s kid=%s", alg, keyID)
+	return &jwt.Issuer{
+		Keys:       keys,
+		Issuer:     GetKeyValueString("jwt", "issuer"),
+		AccessTTL:  time.Duration(GetKeyValueInt("jwt", "accessTTL")) * time.Second,
+		RefreshTTL: time.Duration(GetKeyValueInt("jwt", "refreshTTL")) * time.Second,
+	}, nil
+}
+
+func loadSigningKey(keyID, alg, path string) (*jwt.Key, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Infof("generating jwt signing key: alg=%s path=%s", alg, path)
+		k, err := jwt.GenerateKey(keyID, alg)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = k.MarshalPrivateKey(); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			return nil, fmt.Errorf("无法写入 JWT 密钥 %s: %s", path, err)
+		}
+		return k, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("无法读取 JWT 密钥 %s: %s", path, err)
+	}
+
+	k, err := jwt.ParseKey(keyID, data)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 JWT 密钥 %s: %s", path, err)
+	}
+	if k.Algorithm != alg {
+		return nil, fmt.Errorf("JWT 密钥 %s 是 %s 密钥, 配置的算法为 %s", path, k.Algorithm, alg)
+	}
+	return k, nil
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/nicle-lin/lillian/controller/migrations"
+	"github.com/nicle-lin/mysql"
+)
+
+func migrator(c *cli.Context) *migrations.Migrator {
+	if err := loadConfig(c); err != nil {
+		log.Fatal(err)
+	}
+	db := mysqlSession()
+	return newMigrator(db)
+}
+
+func newMigrator(db *mysql.Mysql) *migrations.Migrator {
+	m, err := migrations.NewMigrator(db.DB)
+	if err != nil {
+		log.Fatalf("error loading migrations: %s", err)
+	}
+	return m
+}
+
+// MigrateUp applies all pending migrations
+func MigrateUp(c *cli.Context) {
+	applied, err := migrator(c).Up()
+	for _, m := range applied {
+		fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(applied) == 0 {
+		fmt.Println("database is up to date")
+	}
+}
+
+// MigrateDown reverts the last --steps migrations
+func MigrateDown(c *cli.Context) {
+	steps := c.Int("steps")
+	if steps <= 0 {
+		log.Fatal("--steps must be positive")
+	}
+
+	reverted, err := migrator(c).Down(steps)
+	for _, m := range reverted {
+		fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// MigrateStatus lists the migrations and when they were applied
+func MigrateStatus(c *cli.Context) {
+	status, err := migrator(c).Status()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, s := range status {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	w.Flush()
+}
+
+// autoMigrate applies pending migrations at server start when
+// [mysql] autoMigrate is enabled
+func autoMigrate(db *mysql.Mysql) {
+	if !GetKeyValueBool("mysql", "autoMigrate") {
+		return
+	}
+	applied, err := newMigrator(db).Up()
+	if err != nil {
+		log.Fatalf("error migrating database: %s", err)
+	}
+	log.Infof("database migrated: applied=%d", len(applied))
+}
@@ -1,70 +1,156 @@
 package server
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/astaxie/beego/session"
 	_ "github.com/astaxie/beego/session/redis"
 	"github.com/codegangsta/cli"
-	"github.com/go-ini/ini"
 	"github.com/nicle-lin/lillian/controller/api"
 	"github.com/nicle-lin/lillian/controller/manager"
-	"github.com/nicle-lin/lillian/helper/auth/builtin"
+	"github.com/nicle-lin/lillian/controller/middleware/cors"
+	"github.com/nicle-lin/lillian/helper/auth"
+	"github.com/nicle-lin/lillian/helper/metrics"
 	"github.com/nicle-lin/lillian/version"
 	"github.com/nicle-lin/mysql"
 	"github.com/nicle-lin/redis"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-const configPath = "config/config.ini"
+func Server(c *cli.Context) {
+	if err := loadConfig(c); err != nil {
+		log.Fatal(err)
+	}
 
-var (
-	cfg *ini.File
-)
+	disableUsageInfo := c.Bool("disable-usage-info")
+	log.Infof("lillian CRM version: %s", version.Version)
 
-func init() {
-	curWorkPath, err := os.Getwd()
+	authenticator, err := newAuthenticator()
 	if err != nil {
 		log.Fatal(err)
 	}
-	configAbsPath := filepath.Join(curWorkPath, configPath)
-	cfg, err = ini.Load(configAbsPath)
+
+	globalSessions, err := Session()
 	if err != nil {
 		log.Fatal(err)
 	}
-}
-
-func Server(c *cli.Context) {
-	disableUsageInfo := c.Bool("disable-usage-info")
-	log.Infof("lillian CRM version: %s", version.Version)
-
-	// default to builtin auth
-	authenticator := builtin.NewAuthenticator("defaultlillian")
-
-	globalSessions := Session()
 	redis := redisSession()
 	mysql := mysqlSession()
+	autoMigrate(mysql)
+
+	security := securityConfig()
+	if security.JWT, err = jwtIssuer(); err != nil {
+		log.Fatal(err)
+	}
 
-	controllerManager, err := manager.NewManager(redis, mysql, globalSessions, disableUsageInfo, authenticator)
+	controllerManager, err := manager.NewManager(redis, mysql, globalSessions, disableUsageInfo, authenticator, security)
 	if err != nil {
 		log.Fatal(err)
 	}
+	registerDBMetrics(mysql)
+	if err := bootstrapAdmin(controllerManager); err != nil {
+		log.Fatalf("error creating initial admin account: %s", err)
+	}
 
 	listenAddr := GetKeyValueString("app", "host")
+	if err := ensureCertificates(GetKeyValueString("app", "tlsCACertPath"), GetKeyValueString("app", "tlsCertPath"),
+		GetKeyValueString("app", "tlsKeyPath"), listenAddr); err != nil {
+		log.Fatal(err)
+	}
+
 	apiConfig := api.ApiConfig{
-		ListenAddr: listenAddr,
-		Manager:    controllerManager,
+		ListenAddr:         listenAddr,
+		Manager:            controllerManager,
+		AuthWhitelistCIDRS: cfg.Section("app").Key("authWhitelistCIDRs").Strings(","),
+		TLSCACertPath:      GetKeyValueString("app", "tlsCACertPath"),
+		TLSCertPath:        GetKeyValueString("app", "tlsCertPath"),
+		TLSKeyPath:         GetKeyValueString("app", "tlsKeyPath"),
+		TLSVerifyClients:   GetKeyValueBool("app", "tlsVerifyClients"),
+		AllowInsecure:      GetKeyValueBool("app", "tlsAllowInsecure"),
+		FrontendDir:        GetKeyValueString("app", "frontendDir"),
+		MetricsToken:       GetKeyValueString("metrics", "token"),
+		OIDC:               oidcAuthenticator(),
+		CORS: cors.Config{
+			AllowedOrigins:   cfg.Section("cors").Key("allowedOrigins").Strings(","),
+			AllowedMethods:   cfg.Section("cors").Key("allowedMethods").Strings(","),
+			AllowedHeaders:   cfg.Section("cors").Key("allowedHeaders").Strings(","),
+			ExposedHeaders:   cfg.Section("cors").Key("exposedHeaders").Strings(","),
+			AllowCredentials: GetKeyValueBool("cors", "allowCredentials"),
+			MaxAge:           GetKeyValueInt("cors", "maxAge"),
+		},
 	}
 
 	lillianApi := api.NewApi(apiConfig)
 
-	if err := lillianApi.Run(); err != nil {
-		log.Fatal(err)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- lillianApi.Run()
+	}()
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			controllerManager.Close(context.Background())
+			log.Fatal(err)
+		}
+	case sig := <-sigc:
+		log.Infof("received %s, shutting down", sig)
+	}
+
+	timeout := time.Duration(GetKeyValueInt("app", "shutdownTimeout")) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := lillianApi.Shutdown(ctx); err != nil {
+		log.Errorf("error draining requests: %s", err)
 	}
+	if err := controllerManager.Close(ctx); err != nil {
+		log.Errorf("error closing manager: %s", err)
+	}
+	log.Info("lillian stopped")
 }
 
-func Session() *session.Manager {
+func securityConfig() manager.SecurityConfig {
+	return manager.SecurityConfig{
+		PasswordPolicy: auth.PasswordPolicy{
+			MinLength:     GetKeyValueInt("security", "passwordMinLength"),
+			RequireUpper:  GetKeyValueBool("security", "passwordRequireUpper"),
+			RequireLower:  GetKeyValueBool("security", "passwordRequireLower"),
+			RequireDigit:  GetKeyValueBool("security", "passwordRequireDigit"),
+			RequireSymbol: GetKeyValueBool("security", "passwordRequireSymbol"),
+			History:       GetKeyValueInt("security", "passwordHistory"),
+		},
+		MaxFailedLogins:      GetKeyValueInt("security", "maxFailedLogins"),
+		MaxFailedLoginsPerIP: GetKeyValueInt("security", "maxFailedLoginsPerIP"),
+		LockoutDuration:      time.Duration(GetKeyValueInt("security", "lockoutDuration")) * time.Second,
+		TOTPIssuer:           GetKeyValueString("security", "totpIssuer"),
+		TOTPRequiredRoles:    cfg.Section("security").Key("totpRequiredRoles").Strings(","),
+		Session:              sessionConfig(),
+	}
+}
+
+func sessionConfig() manager.SessionConfig {
+	// validated when the config was loaded
+	sameSite, _ := parseSameSite(GetKeyValueString("session", "cookieSameSite"))
+	return manager.SessionConfig{
+		CookieName: GetKeyValueString("session", "cookiename"),
+		Lifetime:   time.Duration(GetKeyValueInt("session", "gclifetime")) * time.Second,
+		Secure:     GetKeyValueBool("session", "cookieSecure"),
+		SameSite:   sameSite,
+		Domain:     GetKeyValueString("session", "cookieDomain"),
+	}
+}
+
+func Session() (*session.Manager, error) {
 	log.Debug("setting up session")
 
 	cookiename := GetKeyValueString("session", "cookiename")
@@ -74,11 +160,6 @@ func Session() *session.Manager {
 	port := GetKeyValueString("session", "port")
 	password := GetKeyValueString("session", "password")
 
-	if host == "" || port == "" || password == "" {
-		log.Debug("未配置session")
-		return nil
-	}
-
 	if cookiename == "" {
 		cookiename = "lilliansessionid"
 	}
@@ -96,10 +177,12 @@ func Session() *session.Manager {
 	}
 	globalSessions, err := session.NewManager("redis", cfg)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	go globalSessions.GC()
-	return globalSessions
+	// no GC loop: the redis provider expires sessions through key TTLs and
+	// its SessionGC is a no-op, while GC() re-arms a timer that can't be
+	// stopped on shutdown
+	return globalSessions, nil
 }
 
 func redisSession() *redis.RedisPool {
@@ -107,10 +190,6 @@ func redisSession() *redis.RedisPool {
 	port := GetKeyValueString("redis", "port")
 	password := GetKeyValueString("redis", "password")
 
-	if host == "" || port == "" || password == "" {
-		log.Debug("未配置redis")
-		return nil
-	}
 	return redis.NewRedisPool(host, port, password)
 }
 
@@ -122,24 +201,53 @@ func mysqlSession() *mysql.Mysql {
 	dbname := GetKeyValueString("mysql", "dbname")
 	charset := GetKeyValueString("mysql", "charset")
 
-	if host == "" || port == "" || user == "" || password == "" || dbname == "" {
-		log.Debug("未配置mysql")
-		return nil
+	if charset == "" {
+		charset = "utf8"
 	}
 
-	if dbname == "" {
-		dbname = "utf8"
-	}
-
-	mysqlConnStr := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=%s",
+	mysqlConnStr := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=%s&parseTime=true",
 		user, password, host, port, dbname, charset)
 	return mysql.NewMysql(mysqlConnStr)
 }
 
+// registerDBMetrics exposes the MySQL connection pool statistics
+func registerDBMetrics(db *mysql.Mysql) {
+	gauges := []struct {
+		name, help string
+		value      func(sql.DBStats) float64
+	}{
+		{"lillian_db_open_connections", "Open MySQL connections.",
+			func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"lillian_db_in_use_connections", "MySQL connections in use.",
+			func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"lillian_db_idle_connections", "Idle MySQL connections.",
+			func(s sql.DBStats) float64 { return float64(s.Idle) }},
+		{"lillian_db_wait_count", "Total MySQL connections waited for.",
+			func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"lillian_db_wait_duration_seconds", "Total time blocked waiting for a MySQL connection.",
+			func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+	}
+	for _, g := range gauges {
+		value := g.value
+		metrics.Default.NewGaugeFunc(g.name, g.help, func() float64 {
+			return value(db.Stats())
+		})
+	}
+}
+
 func GetKeyValueString(section, key string) string {
 	return cfg.Section(section).Key(key).String()
 }
 
+func GetKeyValueBool(section, key string) bool {
+	result, err := cfg.Section(section).Key(key).Bool()
+	if err != nil {
+		log.Debug(err)
+		return false
+	}
+	return result
+}
+
 func GetKeyValueInt(section, key string) int {
 	result, err := cfg.Section(section).Key(key).Int()
 	if err != nil {
@@ -0,0 +1,142 @@
+// Package static serves the angularJs frontend. The build in dist is
+// embedded in the binary; during development it can be served from a
+// directory instead.
+package static
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const indexFile = "index.html"
+
+//go:embed all:dist
+var dist embed.FS
+
+var (
+	// hashedName matches assets whose name contains a content hash, e.g.
+	// app.3f2a9c1b.js or vendor-3f2a9c1b.css; they never change and can
+	// be cached forever
+	hashedName = regexp.MustCompile(`[.-][0-9a-f]{8,}\.[a-z0-9]+$`)
+
+	// precompressed lists the encodings tried in order of preference, as
+	// produced next to the assets by the frontend build
+	precompressed = []struct {
+		encoding string
+		ext      string
+	}{
+		{"br", ".br"},
+		{"gzip", ".gz"},
+	}
+)
+
+// Embedded returns the frontend built into the binary
+func Embedded() fs.FS {
+	sub, err := fs.Sub(dist, "dist")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// Dir returns the frontend in a directory, for development
+func Dir(dir string) fs.FS {
+	return os.DirFS(dir)
+}
+
+type handler struct {
+	fsys fs.FS
+}
+
+// Handler serves the files of fsys. Paths that are not files fall back
+// to index.html, so the single page app can handle its own routes.
+func Handler(fsys fs.FS) http.Handler {
+	return &handler{fsys: fsys}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = indexFile
+	}
+
+	if !h.isFile(name) {
+		// a missing asset is an error, anything else is a frontend route
+		if path.Ext(name) != "" {
+			http.NotFound(w, r)
+			return
+		}
+		name = indexFile
+	}
+	h.serveFile(w, r, name)
+}
+
+func (h *handler) isFile(name string) bool {
+	fi, err := fs.Stat(h.fsys, name)
+	return err == nil && !fi.IsDir()
+}
+
+func (h *handler) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	file := name
+	accept := r.Header.Get("Accept-Encoding")
+	for _, p := range precompressed {
+		if acceptsEncoding(accept, p.encoding) && h.isFile(name+p.ext) {
+			file = name + p.ext
+			w.Header().Set("Content-Encoding", p.encoding)
+			break
+		}
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	data, err := fs.ReadFile(h.fsys, file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if hashedName.MatchString(name) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		// index.html has to be checked every time so a new build is
+		// picked up, the etag keeps that cheap
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	sum := sha256.Sum256(data)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+
+	// the content type comes from the uncompressed name
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header allows the
+// encoding; q=0 rules it out
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		if strings.TrimSpace(fields[0]) != encoding {
+			continue
+		}
+		for _, f := range fields[1:] {
+			if q := strings.TrimSpace(f); q == "q=0" || q == "q=0.0" || q == "q=0.00" || q == "q=0.000" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package static
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+var testFS = fstest.MapFS{
+	"index.html":           {Data: []byte("<html>index</html>")},
+	"app.3f2a9c1b.js":      {Data: []byte("console.log('app')")},
+	"app.3f2a9c1b.js.br":   {Data: []byte("brotli")},
+	"app.3f2a9c1b.js.gz":   {Data: []byte("gzip")},
+	"css/style.css":        {Data: []byte("body {}")},
+	"css/style.css.gz":     {Data: []byte("gzip css")},
+	"img/logo.png":         {Data: []byte("png")},
+	"partials/detail.html": {Data: []byte("<div>detail</div>")},
+}
+
+func get(t *testing.T, path string, header map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	res := httptest.NewRecorder()
+	Handler(testFS).ServeHTTP(res, req)
+	return res
+}
+
+func TestServeIndex(t *testing.T) {
+	for _, path := range []string{"/", "/accounts", "/accounts/admin/edit"} {
+		res := get(t, path, nil)
+		if res.Code != http.StatusOK || res.Body.String() != "<html>index</html>" {
+			t.Fatalf("%s: expected index.html; got %d %q", path, res.Code, res.Body.String())
+		}
+		if v := res.Header().Get("Cache-Control"); v != "no-cache" {
+			t.Fatalf("%s: expected no-cache; got %q", path, v)
+		}
+	}
+}
+
+func TestMissingAsset(t *testing.T) {
+	for _, path := range []string{"/app.js", "/img/missing.png", "/../../etc/passwd.txt"} {
+		if res := get(t, path, nil); res.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404; got %d", path, res.Code)
+		}
+	}
+}
+
+func TestPrecompressed(t *testing.T) {
+	tests := []struct {
+		path     string
+		accept   string
+		encoding string
+		body     string
+	}{
+		{"/app.3f2a9c1b.js", "gzip, deflate, br", "br", "brotli"},
+		{"/app.3f2a9c1b.js", "gzip", "gzip", "gzip"},
+		{"/app.3f2a9c1b.js", "br;q=0, gzip", "gzip", "gzip"},
+		{"/app.3f2a9c1b.js", "", "", "console.log('app')"},
+		{"/css/style.css", "br, gzip", "gzip", "gzip css"},
+		{"/img/logo.png", "br, gzip", "", "png"},
+	}
+	for _, test := range tests {
+		res := get(t, test.path, map[string]string{"Accept-Encoding": test.accept})
+		if res.Code != http.StatusOK {
+			t.Fatalf("%s %q: expected 200; got %d", test.path, test.accept, res.Code)
+		}
+		if v := res.Header().Get("Content-Encoding"); v != test.encoding {
+			t.Fatalf("%s %q: expected encoding %q; got %q", test.path, test.accept, test.encoding, v)
+		}
+		if res.Body.String() != test.body {
+			t.Fatalf("%s %q: expected body %q; got %q", test.path, test.accept, test.body, res.Body.String())
+		}
+		if v := res.Header().Get("Vary"); v != "Accept-Encoding" {
+			t.Fatalf("%s: expected Vary: Accept-Encoding; got %q", test.path, v)
+		}
+	}
+
+	res := get(t, "/css/style.css", map[string]string{"Accept-Encoding": "gzip"})
+	if v := res.Header().Get("Content-Type"); v != "text/css; charset=utf-8" {
+		t.Fatalf("expected css content type for compressed file; got %q", v)
+	}
+}
+
+func TestCacheHeaders(t *testing.T) {
+	res := get(t, "/app.3f2a9c1b.js", nil)
+	if v := res.Header().Get("Cache-Control"); v != "public, max-age=31536000, immutable" {
+		t.Fatalf("expected hashed asset to be cached; got %q", v)
+	}
+
+	res = get(t, "/partials/detail.html", nil)
+	if v := res.Header().Get("Cache-Control"); v != "no-cache" {
+		t.Fatalf("expected no-cache; got %q", v)
+	}
+
+	etag := res.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected etag")
+	}
+	res = get(t, "/partials/detail.html", map[string]string{"If-None-Match": etag})
+	if res.Code != http.StatusNotModified {
+		t.Fatalf("expected 304; got %d", res.Code)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/", nil)
+	res := httptest.NewRecorder()
+	Handler(testFS).ServeHTTP(res, req)
+	if res.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405; got %d", res.Code)
+	}
+}
+
+func TestEmbedded(t *testing.T) {
+	if _, err := Embedded().Open(indexFile); err != nil {
+		t.Fatalf("expected embedded index.html: %s", err)
+	}
+}
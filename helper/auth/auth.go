@@ -22,11 +22,35 @@ type (
 		Password  string       `json:"password,omitempty" gorethink:"password"`
 		Tokens    []*AuthToken `json:"-" gorethink:"tokens"`
 		Roles     []string     `json:"roles,omitempty" gorethink:"roles"`
+		// Provider names the authenticator that manages the account, e.g.
+		// builtin or ldap; empty for accounts not yet bound to one
+		Provider string `json:"provider,omitempty" gorethink:"provider"`
+		// TOTPSecret is set on enrolment and only checked at login once
+		// TOTPEnabled is true. RecoveryCodes holds hashed one-time codes.
+		TOTPSecret    string   `json:"-" gorethink:"totp_secret"`
+		TOTPEnabled   bool     `json:"totp_enabled,omitempty" gorethink:"totp_enabled"`
+		RecoveryCodes []string `json:"-" gorethink:"recovery_codes"`
+		// PasswordChangeRequired keeps the account to changing its
+		// password, e.g. the generated initial admin password
+		PasswordChangeRequired bool `json:"password_change_required,omitempty" gorethink:"password_change_required"`
 	}
 
 	AuthToken struct {
 		Token     string `json:"auth_token,omitempty" gorethink:"auth_token"`
 		UserAgent string `json:"user_agent,omitempty" gorethink:"user_agent"`
+		// TOTPEnrollmentRequired tells the client the account has to
+		// enable two-factor authentication before it can use the api
+		TOTPEnrollmentRequired bool `json:"totp_enrollment_required,omitempty" gorethink:"-"`
+		// PasswordChangeRequired tells the client the password has to be
+		// changed before the api can be used
+		PasswordChangeRequired bool `json:"password_change_required,omitempty" gorethink:"-"`
+		// AccessToken and RefreshToken are only set when JWT bearer
+		// tokens are enabled. ExpiresIn is the access token lifetime
+		// in seconds.
+		AccessToken  string `json:"access_token,omitempty" gorethink:"-"`
+		TokenType    string `json:"token_type,omitempty" gorethink:"-"`
+		ExpiresIn    int64  `json:"expires_in,omitempty" gorethink:"-"`
+		RefreshToken string `json:"refresh_token,omitempty" gorethink:"-"`
 	}
 
 	AccessToken struct {
@@ -45,6 +69,13 @@ type (
 		IsUpdateSupported() bool
 		Name() string
 	}
+
+	// RoleMapper is implemented by authenticators that manage the roles
+	// of their users, e.g. from directory groups. Roles returns nil when
+	// the roles are managed in lillian.
+	RoleMapper interface {
+		Roles(username string) ([]string, error)
+	}
 )
 
 func Hash(data string) (string, error) {
@@ -71,3 +102,12 @@ func GetAccessToken(authToken string) (*AccessToken, error) {
 	}, nil
 
 }
+
+// GetBearerToken returns the token of an "Authorization: Bearer" header
+func GetBearerToken(authorization string) (string, error) {
+	parts := strings.SplitN(authorization, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") || strings.TrimSpace(parts[1]) == "" {
+		return "", ErrNoUserInToken
+	}
+	return strings.TrimSpace(parts[1]), nil
+}
@@ -40,3 +40,20 @@ func TestGetAccessToken(t *testing.T) {
 	}
 
 }
+
+func TestGetBearerToken(t *testing.T) {
+	tk, err := GetBearerToken("Bearer " + testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tk != testToken {
+		t.Fatalf("expected token %s; received %s", testToken, tk)
+	}
+
+	for _, h := range []string{"", "Bearer", "Bearer ", "Basic " + testToken, testUser + ":" + testToken} {
+		if _, err := GetBearerToken(h); err == nil {
+			t.Fatalf("expected error for header %q", h)
+		}
+	}
+}
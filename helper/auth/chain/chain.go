@@ -0,0 +1,75 @@
+package chain
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/nicle-lin/lillian/helper/auth"
+)
+
+// ChainAuthenticator tries several authenticators in order, e.g. LDAP for
+// staff and then builtin for external agents
+type ChainAuthenticator struct {
+	providers []auth.Authenticator
+}
+
+func NewAuthenticator(providers ...auth.Authenticator) *ChainAuthenticator {
+	names := make([]string, 0, len(providers))
+	for _, p := range providers {
+		names = append(names, p.Name())
+	}
+	log.Infof("Using authentication chain: providers=%v", names)
+	return &ChainAuthenticator{
+		providers: providers,
+	}
+}
+
+func (c *ChainAuthenticator) Name() string {
+	return "chain"
+}
+
+// Providers returns the authenticators in the order they are tried
+func (c *ChainAuthenticator) Providers() []auth.Authenticator {
+	return c.providers
+}
+
+// Provider returns the authenticator with the name or nil
+func (c *ChainAuthenticator) Provider(name string) auth.Authenticator {
+	for _, p := range c.providers {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Authenticate returns true as soon as one provider accepts the
+// credentials. Errors of earlier providers are only logged, so an
+// unreachable directory does not lock out users of later providers.
+func (c *ChainAuthenticator) Authenticate(username, password, hash string) (bool, error) {
+	var lastErr error
+	for _, p := range c.providers {
+		ok, err := p.Authenticate(username, password, hash)
+		if ok && err == nil {
+			return true, nil
+		}
+		if err != nil {
+			log.Debugf("%s authentication failed: username=%s err=%s", p.Name(), username, err)
+			lastErr = err
+		}
+	}
+	return false, lastErr
+}
+
+// IsUpdateSupported reports whether any provider stores passwords; the
+// manager checks the provider of each account
+func (c *ChainAuthenticator) IsUpdateSupported() bool {
+	for _, p := range c.providers {
+		if p.IsUpdateSupported() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ChainAuthenticator) GenerateToken() (string, error) {
+	return auth.GenerateToken()
+}
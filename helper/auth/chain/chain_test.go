@@ -0,0 +1,57 @@
+package chain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nicle-lin/lillian/helper/auth"
+)
+
+type testAuthenticator struct {
+	name     string
+	password string
+	err      error
+	update   bool
+}
+
+func (a testAuthenticator) Name() string                   { return a.name }
+func (a testAuthenticator) IsUpdateSupported() bool        { return a.update }
+func (a testAuthenticator) GenerateToken() (string, error) { return auth.GenerateToken() }
+func (a testAuthenticator) Authenticate(username, password, hash string) (bool, error) {
+	if a.err != nil {
+		return false, a.err
+	}
+	return password == a.password, nil
+}
+
+func TestChainFallback(t *testing.T) {
+	down := errors.New("connection refused")
+	c := NewAuthenticator(
+		testAuthenticator{name: "ldap", err: down},
+		testAuthenticator{name: "builtin", password: "agent", update: true},
+	)
+
+	if ok, err := c.Authenticate("agent", "agent", ""); !ok || err != nil {
+		t.Fatalf("expected fallback to builtin; received %v %v", ok, err)
+	}
+	if ok, err := c.Authenticate("agent", "wrong", ""); ok || err != down {
+		t.Fatalf("expected failure with %s; received %v %v", down, ok, err)
+	}
+	if !c.IsUpdateSupported() {
+		t.Fatalf("expected update support from builtin")
+	}
+}
+
+func TestProvider(t *testing.T) {
+	c := NewAuthenticator(testAuthenticator{name: "ldap"}, testAuthenticator{name: "builtin"})
+
+	if p := c.Provider("builtin"); p == nil || p.Name() != "builtin" {
+		t.Fatalf("expected builtin provider; received %v", p)
+	}
+	if p := c.Provider("oidc"); p != nil {
+		t.Fatalf("expected no oidc provider; received %v", p)
+	}
+	if n := len(c.Providers()); n != 2 {
+		t.Fatalf("expected 2 providers; received %d", n)
+	}
+}
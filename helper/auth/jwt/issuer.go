@@ -0,0 +1,89 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	DefaultAccessTTL  = 15 * time.Minute
+	DefaultRefreshTTL = 7 * 24 * time.Hour
+)
+
+// Issuer creates and checks the access and refresh tokens of users
+type Issuer struct {
+	Keys       *KeySet
+	Issuer     string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+func (i *Issuer) accessTTL() time.Duration {
+	if i.AccessTTL <= 0 {
+		return DefaultAccessTTL
+	}
+	return i.AccessTTL
+}
+
+func (i *Issuer) refreshTTL() time.Duration {
+	if i.RefreshTTL <= 0 {
+		return DefaultRefreshTTL
+	}
+	return i.RefreshTTL
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (i *Issuer) newToken(username, typ string, ttl time.Duration, now time.Time) (string, *Claims, error) {
+	id, err := newID()
+	if err != nil {
+		return "", nil, err
+	}
+	claims := &Claims{
+		Issuer:    i.Issuer,
+		Subject:   username,
+		ExpiresAt: now.Add(ttl).Unix(),
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ID:        id,
+		Type:      typ,
+	}
+	token, err := i.Keys.Sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
+}
+
+// NewAccessToken returns a signed access token for the user
+func (i *Issuer) NewAccessToken(username string, now time.Time) (string, *Claims, error) {
+	return i.newToken(username, TypeAccess, i.accessTTL(), now)
+}
+
+// NewRefreshToken returns a signed refresh token for the user. The
+// caller has to remember its ID to be able to revoke it.
+func (i *Issuer) NewRefreshToken(username string, now time.Time) (string, *Claims, error) {
+	return i.newToken(username, TypeRefresh, i.refreshTTL(), now)
+}
+
+// Verify checks a token and that it has the type and issuer expected
+func (i *Issuer) Verify(token, typ string, now time.Time) (*Claims, error) {
+	claims, err := i.Keys.Verify(token, now)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Type != typ {
+		return nil, ErrWrongTokenType
+	}
+	if claims.Issuer != i.Issuer || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
@@ -0,0 +1,365 @@
+// Package jwt signs and verifies the JSON Web Tokens lillian issues as
+// short lived access tokens and refresh tokens. It supports HS256, RS256
+// and EdDSA keys and publishes the public keys as a JWKS document.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+
+	// TypeAccess and TypeRefresh tell the two kinds of tokens apart, so
+	// a refresh token can never be used as an access token
+	TypeAccess  = "access"
+	TypeRefresh = "refresh"
+
+	// leeway is tolerated when checking exp and nbf
+	leeway = 30 * time.Second
+)
+
+var (
+	ErrInvalidToken       = errors.New("无效的令牌")
+	ErrTokenExpired       = errors.New("令牌已过期")
+	ErrUnknownKey         = errors.New("未知的令牌签名密钥")
+	ErrWrongTokenType     = errors.New("令牌类型错误")
+	ErrUnsupportedKey     = errors.New("不支持的密钥类型")
+	ErrUnsupportedAlg     = errors.New("不支持的签名算法")
+	ErrNoSigningKey       = errors.New("没有配置签名密钥")
+	ErrSecretTooShort     = errors.New("HS256 密钥至少需要 32 字节")
+	ErrDuplicateKeyID     = errors.New("重复的密钥 ID")
+	ErrPublicKeyNotExists = errors.New("HS256 密钥没有公钥")
+)
+
+type (
+	// Key is a signing key. HS256 keys are shared secrets and are never
+	// published in the JWKS document.
+	Key struct {
+		ID        string
+		Algorithm string
+		secret    []byte
+		private   crypto.Signer
+		public    crypto.PublicKey
+	}
+
+	// KeySet holds the key new tokens are signed with and older keys that
+	// are still accepted, so keys can be rotated without logging users out
+	KeySet struct {
+		signing *Key
+		keys    map[string]*Key
+	}
+
+	Claims struct {
+		Issuer    string `json:"iss,omitempty"`
+		Subject   string `json:"sub,omitempty"`
+		Audience  string `json:"aud,omitempty"`
+		ExpiresAt int64  `json:"exp,omitempty"`
+		IssuedAt  int64  `json:"iat,omitempty"`
+		NotBefore int64  `json:"nbf,omitempty"`
+		ID        string `json:"jti,omitempty"`
+		Type      string `json:"typ,omitempty"`
+	}
+
+	// JWK is a public key in the JWKS document
+	JWK struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		N   string `json:"n,omitempty"`
+		E   string `json:"e,omitempty"`
+		Crv string `json:"crv,omitempty"`
+		X   string `json:"x,omitempty"`
+	}
+
+	JWKS struct {
+		Keys []JWK `json:"keys"`
+	}
+
+	header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid,omitempty"`
+		Typ string `json:"typ,omitempty"`
+	}
+)
+
+// NewHMACKey returns an HS256 key for a shared secret
+func NewHMACKey(id string, secret []byte) (*Key, error) {
+	if len(secret) < 32 {
+		return nil, ErrSecretTooShort
+	}
+	return &Key{ID: id, Algorithm: HS256, secret: secret}, nil
+}
+
+// NewKey returns an RS256 or EdDSA key for a private key
+func NewKey(id string, private crypto.Signer) (*Key, error) {
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		return &Key{ID: id, Algorithm: RS256, private: private, public: private.Public()}, nil
+	case ed25519.PrivateKey:
+		return &Key{ID: id, Algorithm: EdDSA, private: private, public: private.Public()}, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// NewPublicKey returns a key that only verifies tokens, e.g. one that
+// was rotated out and whose private key is no longer available
+func NewPublicKey(id string, public crypto.PublicKey) (*Key, error) {
+	switch public.(type) {
+	case *rsa.PublicKey:
+		return &Key{ID: id, Algorithm: RS256, public: public}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: id, Algorithm: EdDSA, public: public}, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// ParseKey reads a PEM encoded RSA or Ed25519 private or public key
+func ParseKey(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("jwt: no PEM data for key %s", id)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return NewKey(id, k)
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := k.(crypto.Signer)
+		if !ok {
+			return nil, ErrUnsupportedKey
+		}
+		return NewKey(id, signer)
+	case "PUBLIC KEY":
+		k, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return NewPublicKey(id, k)
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// GenerateKey returns a new RS256 or EdDSA key
+func GenerateKey(id, alg string) (*Key, error) {
+	switch alg {
+	case RS256:
+		k, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		return NewKey(id, k)
+	case EdDSA:
+		_, k, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return NewKey(id, k)
+	}
+	return nil, ErrUnsupportedAlg
+}
+
+// MarshalPrivateKey returns the PKCS#8 PEM encoding of the private key
+func (k *Key) MarshalPrivateKey() ([]byte, error) {
+	if k.private == nil {
+		return nil, ErrUnsupportedKey
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(k.private)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func (k *Key) sign(data []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	case RS256:
+		if k.private == nil {
+			return nil, ErrNoSigningKey
+		}
+		sum := sha256.Sum256(data)
+		return k.private.Sign(rand.Reader, sum[:], crypto.SHA256)
+	case EdDSA:
+		if k.private == nil {
+			return nil, ErrNoSigningKey
+		}
+		return k.private.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	return nil, ErrUnsupportedAlg
+}
+
+func (k *Key) verify(data, sig []byte) bool {
+	switch k.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(data)
+		return hmac.Equal(mac.Sum(nil), sig)
+	case RS256:
+		pub, ok := k.public.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		sum := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	case EdDSA:
+		pub, ok := k.public.(ed25519.PublicKey)
+		if !ok {
+			return false
+		}
+		return ed25519.Verify(pub, data, sig)
+	}
+	return false
+}
+
+// JWK returns the public part of the key
+func (k *Key) JWK() (JWK, error) {
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: k.ID,
+			Use: "sig",
+			Alg: RS256,
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Kid: k.ID,
+			Use: "sig",
+			Alg: EdDSA,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}, nil
+	}
+	return JWK{}, ErrPublicKeyNotExists
+}
+
+// NewKeySet returns a key set that signs with the first key and also
+// accepts tokens signed with the others
+func NewKeySet(signing *Key, others ...*Key) (*KeySet, error) {
+	if signing == nil || (signing.secret == nil && signing.private == nil) {
+		return nil, ErrNoSigningKey
+	}
+	ks := &KeySet{
+		signing: signing,
+		keys:    map[string]*Key{},
+	}
+	for _, k := range append([]*Key{signing}, others...) {
+		if _, ok := ks.keys[k.ID]; ok {
+			return nil, ErrDuplicateKeyID
+		}
+		ks.keys[k.ID] = k
+	}
+	return ks, nil
+}
+
+// JWKS returns the public keys; HS256 secrets are left out
+func (ks *KeySet) JWKS() *JWKS {
+	set := &JWKS{Keys: []JWK{}}
+	for _, k := range ks.keys {
+		if jwk, err := k.JWK(); err == nil {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+// Sign returns the signed compact serialization of the claims
+func (ks *KeySet) Sign(claims *Claims) (string, error) {
+	h, err := json.Marshal(&header{Alg: ks.signing.Algorithm, Kid: ks.signing.ID, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sig, err := ks.signing.sign([]byte(signed))
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Verify checks the signature and lifetime of a token and returns its
+// claims. The algorithm has to match the key the kid refers to, so a
+// public key can never be used as an HMAC secret.
+func (ks *KeySet) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, ErrInvalidToken
+	}
+	key, ok := ks.keys[h.Kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if h.Alg != key.Algorithm {
+		return nil, ErrInvalidToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !key.verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.ExpiresAt == 0 || now.Add(-leeway).Unix() > claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(leeway).Unix() < claims.NotBefore {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,175 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func testIssuer(t *testing.T, signing *Key, others ...*Key) *Issuer {
+	ks, err := NewKeySet(signing, others...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Issuer{Keys: ks, Issuer: "lillian"}
+}
+
+func TestSignVerify(t *testing.T) {
+	hmacKey, err := NewHMACKey("hmac", []byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []*Key{hmacKey}
+	for _, alg := range []string{RS256, EdDSA} {
+		k, err := GenerateKey(strings.ToLower(alg), alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+
+	now := time.Now()
+	for _, k := range keys {
+		iss := testIssuer(t, k)
+		token, _, err := iss.NewAccessToken("admin", now)
+		if err != nil {
+			t.Fatalf("%s: %s", k.Algorithm, err)
+		}
+		claims, err := iss.Verify(token, TypeAccess, now)
+		if err != nil {
+			t.Fatalf("%s: %s", k.Algorithm, err)
+		}
+		if claims.Subject != "admin" {
+			t.Fatalf("expected subject admin; received %s", claims.Subject)
+		}
+
+		if _, err := iss.Verify(token, TypeRefresh, now); err != ErrWrongTokenType {
+			t.Fatalf("%s: expected ErrWrongTokenType; received %v", k.Algorithm, err)
+		}
+		if _, err := iss.Verify(token, TypeAccess, now.Add(time.Hour)); err != ErrTokenExpired {
+			t.Fatalf("%s: expected ErrTokenExpired; received %v", k.Algorithm, err)
+		}
+
+		// change the subject and keep the signature
+		parts := strings.Split(token, ".")
+		parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"lillian","sub":"root","exp":` +
+			"9999999999" + `,"typ":"access"}`))
+		if _, err := iss.Verify(strings.Join(parts, "."), TypeAccess, now); err != ErrInvalidToken {
+			t.Fatalf("%s: expected ErrInvalidToken; received %v", k.Algorithm, err)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, err := GenerateKey("old", EdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := GenerateKey("new", RS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	token, _, err := testIssuer(t, oldKey).NewAccessToken("admin", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := testIssuer(t, newKey).Verify(token, TypeAccess, now); err != ErrUnknownKey {
+		t.Fatalf("expected ErrUnknownKey; received %v", err)
+	}
+
+	// only the public part of the old key is left
+	oldPublic, err := NewPublicKey(oldKey.ID, oldKey.public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := testIssuer(t, newKey, oldPublic)
+	if _, err := iss.Verify(token, TypeAccess, now); err != nil {
+		t.Fatalf("expected token of rotated key to verify; received %v", err)
+	}
+
+	jwks := iss.Keys.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("expected 2 keys; received %d", len(jwks.Keys))
+	}
+
+	if _, err := NewKeySet(oldPublic); err != ErrNoSigningKey {
+		t.Fatalf("expected ErrNoSigningKey; received %v", err)
+	}
+	if _, err := NewKeySet(newKey, newKey); err != ErrDuplicateKeyID {
+		t.Fatalf("expected ErrDuplicateKeyID; received %v", err)
+	}
+}
+
+func TestHMACNotPublished(t *testing.T) {
+	k, err := NewHMACKey("hmac", []byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err := NewKeySet(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ks.JWKS().Keys); n != 0 {
+		t.Fatalf("expected no published keys; received %d", n)
+	}
+
+	if _, err := NewHMACKey("short", []byte("secret")); err != ErrSecretTooShort {
+		t.Fatalf("expected ErrSecretTooShort; received %v", err)
+	}
+}
+
+// a token signed with HS256 using the published RSA key as secret must
+// not verify
+func TestAlgorithmConfusion(t *testing.T) {
+	rsaKey, err := GenerateKey("rsa", RS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := testIssuer(t, rsaKey)
+	jwk, err := rsaKey.JWK()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged, err := NewHMACKey("rsa", []byte(jwk.N))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := testIssuer(t, forged).NewAccessToken("admin", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := iss.Verify(token, TypeAccess, time.Now()); err != ErrInvalidToken {
+		t.Fatalf("expected ErrInvalidToken; received %v", err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	for _, alg := range []string{RS256, EdDSA} {
+		k, err := GenerateKey("k1", alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := k.MarshalPrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseKey("k1", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.Algorithm != alg {
+			t.Fatalf("expected algorithm %s; received %s", alg, parsed.Algorithm)
+		}
+	}
+
+	if _, err := ParseKey("k1", []byte("not a key")); err == nil {
+		t.Fatal("expected error for invalid key")
+	}
+}
@@ -1,6 +1,8 @@
 package ldap
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -9,45 +11,199 @@ import (
 	goldap "gopkg.in/ldap.v1"
 )
 
+const (
+	// SecurityNone talks plain LDAP, SecurityStartTLS upgrades the
+	// connection and SecurityLDAPS connects with TLS right away
+	SecurityNone     = "none"
+	SecurityStartTLS = "starttls"
+	SecurityLDAPS    = "ldaps"
+
+	defaultUserFilter     = "(uid={username})"
+	defaultGroupFilter    = "(member={dn})"
+	defaultGroupAttribute = "cn"
+)
+
+var (
+	ErrUserNotFound     = errors.New("ldap: 找不到用户")
+	ErrMultipleUsers    = errors.New("ldap: 找到多个匹配的用户")
+	ErrInvalidSecurity  = errors.New("ldap: security 需要是 none, starttls 或 ldaps")
+	ErrEmptyCredentials = errors.New("ldap: 需要用户名和密码")
+)
+
 type (
-	LdapAuthenticator struct {
+	// Conn is the part of an LDAP connection the authenticator uses
+	Conn interface {
+		Bind(username, password string) error
+		Search(req *goldap.SearchRequest) (*goldap.SearchResult, error)
+		Close()
+	}
+
+	// Config describes the directory. Without BindDN users bind directly
+	// with a DN built from BaseDN ("cn={username},BaseDN" or a template
+	// containing {username}); with BindDN the service account searches
+	// for the user DN with UserFilter below UserSearchBase first.
+	Config struct {
 		Server             string
 		Port               int
-		BaseDN             string
+		Security           string
+		InsecureSkipVerify bool
+		TLSConfig          *tls.Config
+
+		BaseDN       string
+		BindDN       string
+		BindPassword string
+
+		UserSearchBase string
+		UserFilter     string
+
+		// group lookups are enabled by GroupSearchBase; groups are matched
+		// with GroupFilter and named by GroupAttribute, then translated
+		// with GroupRoles
+		GroupSearchBase string
+		GroupFilter     string
+		GroupAttribute  string
+		GroupRoles      map[string]string
+
 		DefaultAccessLevel string
 		AutocreateUsers    bool
 	}
+
+	LdapAuthenticator struct {
+		Config
+
+		// Dial opens the connection; tests replace it with a fake
+		// directory
+		Dial func() (Conn, error)
+	}
 )
 
-func NewAuthenticator(server string, port int, baseDN string, autocreateUsers bool, defaultAccessLevel string) auth.Authenticator {
-	log.Infof("Using LDAP authentication: server=%s port=%d basedn=%s",
-		server, port, baseDN)
-	return &LdapAuthenticator{
-		Server:             server,
-		Port:               port,
-		BaseDN:             baseDN,
-		AutocreateUsers:    autocreateUsers,
-		DefaultAccessLevel: defaultAccessLevel,
+func NewAuthenticator(config Config) (*LdapAuthenticator, error) {
+	switch config.Security {
+	case "":
+		config.Security = SecurityNone
+	case SecurityNone, SecurityStartTLS, SecurityLDAPS:
+	default:
+		return nil, ErrInvalidSecurity
+	}
+	if config.UserSearchBase == "" {
+		config.UserSearchBase = config.BaseDN
+	}
+	if config.UserFilter == "" {
+		config.UserFilter = defaultUserFilter
 	}
+	if config.GroupFilter == "" {
+		config.GroupFilter = defaultGroupFilter
+	}
+	if config.GroupAttribute == "" {
+		config.GroupAttribute = defaultGroupAttribute
+	}
+
+	log.Infof("Using LDAP authentication: server=%s port=%d security=%s basedn=%s",
+		config.Server, config.Port, config.Security, config.BaseDN)
+	a := &LdapAuthenticator{Config: config}
+	a.Dial = a.dial
+	return a, nil
 }
 
 func (a LdapAuthenticator) Name() string {
 	return "ldap"
 }
 
+func (a LdapAuthenticator) tlsConfig() *tls.Config {
+	c := &tls.Config{}
+	if a.TLSConfig != nil {
+		c = a.TLSConfig.Clone()
+	}
+	if c.ServerName == "" {
+		c.ServerName = a.Server
+	}
+	if a.InsecureSkipVerify {
+		c.InsecureSkipVerify = true
+	}
+	return c
+}
+
+func (a LdapAuthenticator) dial() (Conn, error) {
+	addr := fmt.Sprintf("%s:%d", a.Server, a.Port)
+	if a.Security == SecurityLDAPS {
+		return goldap.DialTLS("tcp", addr, a.tlsConfig())
+	}
+
+	l, err := goldap.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if a.Security == SecurityStartTLS {
+		if err := l.StartTLS(a.tlsConfig()); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// escapeFilter escapes the characters with a meaning in search filters
+// (RFC 4515) so usernames cannot change the filter
+func escapeFilter(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// userDN finds the DN of the user, either by searching as the service
+// account or from the BaseDN template
+func (a LdapAuthenticator) userDN(l Conn, username string) (string, error) {
+	if a.BindDN == "" {
+		if strings.Contains(a.BaseDN, "{username}") {
+			return strings.Replace(a.BaseDN, "{username}", username, -1), nil
+		}
+		return fmt.Sprintf("cn=%s,%s", username, a.BaseDN), nil
+	}
+
+	if err := l.Bind(a.BindDN, a.BindPassword); err != nil {
+		return "", fmt.Errorf("ldap: service account bind failed: %s", err)
+	}
+
+	filter := strings.Replace(a.UserFilter, "{username}", escapeFilter(username), -1)
+	res, err := l.Search(goldap.NewSearchRequest(a.UserSearchBase, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases,
+		2, 0, false, filter, []string{"dn"}, nil))
+	if err != nil {
+		return "", err
+	}
+	switch len(res.Entries) {
+	case 0:
+		return "", ErrUserNotFound
+	case 1:
+		return res.Entries[0].DN, nil
+	}
+	return "", ErrMultipleUsers
+}
+
 func (a LdapAuthenticator) Authenticate(username, password, hash string) (bool, error) {
 	log.Debugf("ldap authentication: username=%s", username)
-	l, err := goldap.Dial("tcp", fmt.Sprintf("%s:%d", a.Server, a.Port))
+	// an empty password would be an anonymous bind, which most
+	// directories accept
+	if username == "" || password == "" {
+		return false, ErrEmptyCredentials
+	}
+
+	l, err := a.Dial()
 	if err != nil {
 		log.Error(err)
 		return false, err
 	}
 	defer l.Close()
 
-	dn := fmt.Sprintf("cn=%s,%s", username, a.BaseDN)
-
-	if strings.Contains(a.BaseDN, "{username}") {
-		dn = strings.Replace(a.BaseDN, "{username}", username, -1)
+	dn, err := a.userDN(l, username)
+	if err != nil {
+		return false, err
 	}
 
 	log.Debugf("ldap authentication: dn=%s", dn)
@@ -61,6 +217,54 @@ func (a LdapAuthenticator) Authenticate(username, password, hash string) (bool,
 	return true, nil
 }
 
+// Roles looks up the groups of the user and maps them to lillian roles.
+// Users in none of the mapped groups get DefaultAccessLevel. It returns
+// nil when group lookups are not configured, so the roles assigned in
+// lillian are kept.
+func (a LdapAuthenticator) Roles(username string) ([]string, error) {
+	if a.GroupSearchBase == "" {
+		return nil, nil
+	}
+	if a.BindDN == "" {
+		return nil, errors.New("ldap: 查询用户组需要配置 bindDN")
+	}
+
+	l, err := a.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+
+	dn, err := a.userDN(l, username)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := strings.Replace(a.GroupFilter, "{dn}", escapeFilter(dn), -1)
+	filter = strings.Replace(filter, "{username}", escapeFilter(username), -1)
+	res, err := l.Search(goldap.NewSearchRequest(a.GroupSearchBase, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases,
+		0, 0, false, filter, []string{a.GroupAttribute}, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []string{}
+	for _, entry := range res.Entries {
+		for _, group := range entry.GetAttributeValues(a.GroupAttribute) {
+			role, ok := a.GroupRoles[group]
+			if !ok || containsString(roles, role) {
+				continue
+			}
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 && a.DefaultAccessLevel != "" {
+		roles = append(roles, a.DefaultAccessLevel)
+	}
+	log.Debugf("ldap roles: username=%s roles=%s", username, strings.Join(roles, ","))
+	return roles, nil
+}
+
 func (a LdapAuthenticator) IsUpdateSupported() bool {
 	return false
 }
@@ -68,3 +272,12 @@ func (a LdapAuthenticator) IsUpdateSupported() bool {
 func (a LdapAuthenticator) GenerateToken() (string, error) {
 	return auth.GenerateToken()
 }
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
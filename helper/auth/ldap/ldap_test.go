@@ -0,0 +1,208 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+	"testing"
+
+	goldap "gopkg.in/ldap.v1"
+)
+
+// fakeDirectory is an in-process directory with a service account, users
+// and groups. It understands the (attr=value) filters the authenticator
+// builds.
+//
+// It replaces the connection through Dial rather than listening on a
+// socket: gopkg.in/ldap.v1 has no server side, so these tests cover the
+// bind and search logic, the filters and the group mapping, but not the
+// wire protocol or the StartTLS handshake. The TLS settings handed to
+// the client are checked in TestTLSConfig.
+type fakeDirectory struct {
+	passwords map[string]string
+	users     map[string]string
+	groups    map[string][]string
+	bound     string
+	searches  []string
+}
+
+var errInvalidCredentials = errors.New("LDAP Result Code 49: Invalid Credentials")
+
+func newFakeDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		passwords: map[string]string{
+			"cn=svc,dc=example,dc=com":             "svcpass",
+			"uid=jane,ou=people,dc=example,dc=com": "janepass",
+			"uid=joe,ou=people,dc=example,dc=com":  "joepass",
+		},
+		users: map[string]string{
+			"jane": "uid=jane,ou=people,dc=example,dc=com",
+			"joe":  "uid=joe,ou=people,dc=example,dc=com",
+		},
+		groups: map[string][]string{
+			"sales": {"uid=jane,ou=people,dc=example,dc=com"},
+			"it":    {"uid=jane,ou=people,dc=example,dc=com"},
+		},
+	}
+}
+
+func (d *fakeDirectory) Bind(username, password string) error {
+	if p, ok := d.passwords[username]; !ok || p != password {
+		return errInvalidCredentials
+	}
+	d.bound = username
+	return nil
+}
+
+func (d *fakeDirectory) Search(req *goldap.SearchRequest) (*goldap.SearchResult, error) {
+	if d.bound == "" {
+		return nil, errors.New("anonymous search not allowed")
+	}
+	d.searches = append(d.searches, req.Filter)
+
+	f := strings.TrimSuffix(strings.TrimPrefix(req.Filter, "("), ")")
+	parts := strings.SplitN(f, "=", 2)
+	res := &goldap.SearchResult{}
+	switch parts[0] {
+	case "uid":
+		if dn, ok := d.users[parts[1]]; ok {
+			res.Entries = append(res.Entries, &goldap.Entry{DN: dn})
+		}
+	case "member":
+		for name, members := range d.groups {
+			for _, m := range members {
+				if m == parts[1] {
+					res.Entries = append(res.Entries, &goldap.Entry{
+						DN:         "cn=" + name + ",ou=groups,dc=example,dc=com",
+						Attributes: []*goldap.EntryAttribute{{Name: "cn", Values: []string{name}}},
+					})
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+func (d *fakeDirectory) Close() {}
+
+func newTestAuthenticator(t *testing.T, d *fakeDirectory, config Config) *LdapAuthenticator {
+	a, err := NewAuthenticator(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Dial = func() (Conn, error) {
+		d.bound = ""
+		return d, nil
+	}
+	return a
+}
+
+func searchConfig() Config {
+	return Config{
+		BaseDN:          "dc=example,dc=com",
+		BindDN:          "cn=svc,dc=example,dc=com",
+		BindPassword:    "svcpass",
+		UserSearchBase:  "ou=people,dc=example,dc=com",
+		GroupSearchBase: "ou=groups,dc=example,dc=com",
+		GroupRoles: map[string]string{
+			"sales": "events:ro",
+			"it":    "admin",
+		},
+		DefaultAccessLevel: "extensions:ro",
+	}
+}
+
+func TestSearchBind(t *testing.T) {
+	d := newFakeDirectory()
+	a := newTestAuthenticator(t, d, searchConfig())
+
+	ok, err := a.Authenticate("jane", "janepass", "")
+	if !ok || err != nil {
+		t.Fatalf("expected jane to authenticate; received %v %v", ok, err)
+	}
+	if d.bound != "uid=jane,ou=people,dc=example,dc=com" {
+		t.Fatalf("expected bind as the found dn; received %s", d.bound)
+	}
+
+	if ok, _ := a.Authenticate("jane", "wrong", ""); ok {
+		t.Fatalf("expected wrong password to fail")
+	}
+	if _, err := a.Authenticate("nobody", "pass", ""); err != ErrUserNotFound {
+		t.Fatalf("expected %s; received %v", ErrUserNotFound, err)
+	}
+	if _, err := a.Authenticate("jane", "", ""); err != ErrEmptyCredentials {
+		t.Fatalf("expected %s for an empty password; received %v", ErrEmptyCredentials, err)
+	}
+}
+
+func TestSearchFilterIsEscaped(t *testing.T) {
+	d := newFakeDirectory()
+	a := newTestAuthenticator(t, d, searchConfig())
+
+	a.Authenticate("*)(uid=*", "pass", "")
+	if len(d.searches) != 1 || d.searches[0] != `(uid=\2a\29\28uid=\2a)` {
+		t.Fatalf("expected escaped filter; received %v", d.searches)
+	}
+}
+
+func TestDirectBind(t *testing.T) {
+	d := newFakeDirectory()
+	a := newTestAuthenticator(t, d, Config{BaseDN: "uid={username},ou=people,dc=example,dc=com"})
+
+	if ok, err := a.Authenticate("joe", "joepass", ""); !ok || err != nil {
+		t.Fatalf("expected joe to authenticate; received %v %v", ok, err)
+	}
+	if len(d.searches) != 0 {
+		t.Fatalf("expected no searches without a service account; received %v", d.searches)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	d := newFakeDirectory()
+	a := newTestAuthenticator(t, d, searchConfig())
+
+	roles, err := a.Roles("jane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 2 || !containsString(roles, "events:ro") || !containsString(roles, "admin") {
+		t.Fatalf("expected roles events:ro and admin; received %v", roles)
+	}
+
+	roles, err = a.Roles("joe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 1 || roles[0] != "extensions:ro" {
+		t.Fatalf("expected default role for joe; received %v", roles)
+	}
+
+	cfg := searchConfig()
+	cfg.GroupSearchBase = ""
+	a = newTestAuthenticator(t, d, cfg)
+	if roles, err := a.Roles("jane"); roles != nil || err != nil {
+		t.Fatalf("expected no roles without group lookups; received %v %v", roles, err)
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	a := LdapAuthenticator{Config: Config{Server: "ldap.example.com", Security: SecurityStartTLS}}
+	if c := a.tlsConfig(); c.ServerName != "ldap.example.com" || c.InsecureSkipVerify {
+		t.Fatalf("expected certificates verified for the server name; received %q %v", c.ServerName, c.InsecureSkipVerify)
+	}
+
+	a.InsecureSkipVerify = true
+	a.TLSConfig = &tls.Config{ServerName: "ldap.internal"}
+	if c := a.tlsConfig(); c.ServerName != "ldap.internal" || !c.InsecureSkipVerify {
+		t.Fatalf("expected the configured server name and skip verify; received %q %v", c.ServerName, c.InsecureSkipVerify)
+	}
+	if a.TLSConfig.InsecureSkipVerify {
+		t.Fatal("expected the configured tls config not to be modified")
+	}
+}
+
+func TestInvalidSecurity(t *testing.T) {
+	if _, err := NewAuthenticator(Config{Security: "ssl"}); err != ErrInvalidSecurity {
+		t.Fatalf("expected %s; received %v", ErrInvalidSecurity, err)
+	}
+}
@@ -0,0 +1,499 @@
+// Package oidc authenticates users against an OpenID Connect provider
+// such as Google Workspace or Microsoft Entra ID using the authorization
+// code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nicle-lin/lillian/helper/auth"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+	// clockSkew is tolerated when checking the token lifetime
+	clockSkew = time.Minute
+)
+
+var (
+	ErrInvalidIDToken    = errors.New("无效的 id_token")
+	ErrIDTokenExpired    = errors.New("id_token 已过期")
+	ErrInvalidNonce      = errors.New("id_token nonce 不匹配")
+	ErrUnknownSigningKey = errors.New("找不到 id_token 的签名密钥")
+	ErrNoUsernameClaim   = errors.New("id_token 中没有用户名")
+	ErrEmailNotVerified  = errors.New("邮箱没有经过验证")
+	ErrDomainNotAllowed  = errors.New("邮箱域名不允许登陆")
+	ErrGroupNotAllowed   = errors.New("不在允许登陆的用户组中")
+)
+
+type (
+	// Config describes the client registration at the provider and how
+	// the id_token claims map to lillian accounts
+	Config struct {
+		IssuerURL    string
+		ClientID     string
+		ClientSecret string
+		RedirectURL  string
+		Scopes       []string
+		// UsernameClaim names the claim used as the username, email by
+		// default. RolesClaim names a string or list claim, e.g. groups,
+		// whose values are translated with RoleMapping.
+		UsernameClaim   string
+		RolesClaim      string
+		RoleMapping     map[string]string
+		DefaultRoles    []string
+		AutocreateUsers bool
+		// AllowedDomains limits logins to verified emails of these
+		// domains. AllowedGroups limits them to users with one of these
+		// values in the RolesClaim. Both are checked before any account
+		// is created.
+		AllowedDomains []string
+		AllowedGroups  []string
+		// PostLoginURL is where browsers are sent after a login
+		PostLoginURL string
+		HTTPClient   *http.Client
+	}
+
+	// Metadata is the part of the discovery document lillian uses
+	Metadata struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+
+	// Claims are the decoded id_token payload
+	Claims map[string]interface{}
+
+	// Identity is the lillian account an id_token maps to
+	Identity struct {
+		Username string
+		Roles    []string
+	}
+
+	OidcAuthenticator struct {
+		Config
+
+		mu       sync.Mutex
+		metadata *Metadata
+		keys     map[string]*rsa.PublicKey
+	}
+)
+
+func NewAuthenticator(config Config) *OidcAuthenticator {
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "email"
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+	if config.PostLoginURL == "" {
+		config.PostLoginURL = "/"
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	config.IssuerURL = strings.TrimRight(config.IssuerURL, "/")
+
+	log.Infof("Using OIDC authentication: issuer=%s client=%s", config.IssuerURL, config.ClientID)
+	return &OidcAuthenticator{
+		Config: config,
+		keys:   map[string]*rsa.PublicKey{},
+	}
+}
+
+func (a *OidcAuthenticator) Name() string {
+	return "oidc"
+}
+
+// Authenticate always fails: the provider never sees passwords, users
+// log in through the redirect flow instead
+func (a *OidcAuthenticator) Authenticate(username, password, hash string) (bool, error) {
+	return false, nil
+}
+
+func (a *OidcAuthenticator) IsUpdateSupported() bool {
+	return false
+}
+
+func (a *OidcAuthenticator) GenerateToken() (string, error) {
+	return auth.GenerateToken()
+}
+
+// RandomString returns a url safe random value for state, nonce and the
+// PKCE code verifier
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge returns the S256 PKCE challenge for a verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (a *OidcAuthenticator) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s returned %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Discover loads and caches the provider's discovery document
+func (a *OidcAuthenticator) Discover(ctx context.Context) (*Metadata, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.metadata != nil {
+		return a.metadata, nil
+	}
+
+	var md Metadata
+	if err := a.getJSON(ctx, a.IssuerURL+discoveryPath, &md); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(md.Issuer, "/") != a.IssuerURL {
+		return nil, fmt.Errorf("oidc: issuer mismatch: expected %s; received %s", a.IssuerURL, md.Issuer)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: incomplete discovery document from %s", a.IssuerURL)
+	}
+	a.metadata = &md
+	return a.metadata, nil
+}
+
+// AuthCodeURL returns the provider URL browsers are sent to for login
+func (a *OidcAuthenticator) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	md, err := a.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", a.ClientID)
+	v.Set("redirect_uri", a.RedirectURL)
+	v.Set("scope", strings.Join(a.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", CodeChallenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(md.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return md.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange trades the authorization code for tokens and returns the raw
+// id_token
+func (a *OidcAuthenticator) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	md, err := a.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", a.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest("POST", md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+
+	resp, err := a.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc: token endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return "", err
+	}
+	if tokens.IDToken == "" {
+		return "", ErrInvalidIDToken
+	}
+	return tokens.IDToken, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (k jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// signingKey returns the RSA key with the kid, refetching the key set
+// once for unknown ids since providers rotate their keys
+func (a *OidcAuthenticator) signingKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	a.mu.Lock()
+	key, ok := a.keys[kid]
+	a.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	md, err := a.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := a.getJSON(ctx, md.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		pub, err := k.rsaPublicKey()
+		if err != nil {
+			log.Warnf("oidc: skipping invalid key %s: %s", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	a.mu.Lock()
+	a.keys = keys
+	a.mu.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownSigningKey
+}
+
+// Verify checks the signature, issuer, audience, lifetime and nonce of
+// an RS256 id_token and returns its claims
+func (a *OidcAuthenticator) Verify(ctx context.Context, rawIDToken, nonce string) (Claims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("oidc: unsupported id_token algorithm %q", header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	key, err := a.signingKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	md, err := a.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if claims.String("iss") != md.Issuer {
+		return nil, fmt.Errorf("oidc: unexpected issuer %q", claims.String("iss"))
+	}
+	if !containsString(claims.Strings("aud"), a.ClientID) {
+		return nil, fmt.Errorf("oidc: id_token not issued for client %s", a.ClientID)
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || time.Now().Add(-clockSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, ErrIDTokenExpired
+	}
+	if claims.String("nonce") != nonce {
+		return nil, ErrInvalidNonce
+	}
+	return claims, nil
+}
+
+// Identity maps the claims to a username and lillian roles. Roles are
+// nil when neither RolesClaim nor DefaultRoles yields any role, so
+// existing accounts keep the roles assigned in lillian.
+func (a *OidcAuthenticator) Identity(claims Claims) (*Identity, error) {
+	username := claims.String(a.UsernameClaim)
+	if username == "" {
+		return nil, ErrNoUsernameClaim
+	}
+
+	// anybody can put any address into an account at most providers, so
+	// an email only identifies someone once the provider verified it
+	if a.UsernameClaim == "email" || len(a.AllowedDomains) > 0 {
+		if verified, _ := claims["email_verified"].(bool); !verified {
+			return nil, ErrEmailNotVerified
+		}
+	}
+	if len(a.AllowedDomains) > 0 {
+		email := claims.String("email")
+		domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+		if !strings.Contains(email, "@") || !containsFold(a.AllowedDomains, domain) {
+			return nil, ErrDomainNotAllowed
+		}
+	}
+	if len(a.AllowedGroups) > 0 {
+		allowed := false
+		for _, g := range claims.Strings(a.RolesClaim) {
+			if containsString(a.AllowedGroups, g) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, ErrGroupNotAllowed
+		}
+	}
+
+	var roles []string
+	if a.RolesClaim != "" {
+		for _, v := range claims.Strings(a.RolesClaim) {
+			role := v
+			if len(a.RoleMapping) > 0 {
+				mapped, ok := a.RoleMapping[v]
+				if !ok {
+					continue
+				}
+				role = mapped
+			}
+			if !containsString(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	if len(roles) == 0 && len(a.DefaultRoles) > 0 {
+		roles = append(roles, a.DefaultRoles...)
+	}
+
+	return &Identity{
+		Username: username,
+		Roles:    roles,
+	}, nil
+}
+
+// String returns a string claim or an empty string
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns a claim that may be a single string or a list of them
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, i := range v {
+			if s, ok := i.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(seg, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(strings.TrimPrefix(v, "@"), s) {
+			return true
+		}
+	}
+	return false
+}
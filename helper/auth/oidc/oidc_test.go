@@ -0,0 +1,251 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockProvider is a minimal OpenID Connect provider that issues an
+// id_token for a single authorization code
+type mockProvider struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	claims    map[string]interface{}
+	challenge string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key}
+
+	mux := http.NewServeMux()
+	discovery := func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/keys",
+		})
+	}
+	mux.HandleFunc(discoveryPath, discovery)
+	// a tenant path that reports the wrong issuer
+	mux.HandleFunc("/tenant"+discoveryPath, discovery)
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "lillian" || secret != "secret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		if r.FormValue("code") != "good-code" || CodeChallenge(r.FormValue("code_verifier")) != p.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"id_token":     p.sign(t, p.claims),
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *mockProvider) sign(t *testing.T, claims map[string]interface{}) string {
+	enc := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": "RS256", "kid": "test"}) + "." + enc(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (p *mockProvider) validClaims(nonce string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            p.URL,
+		"aud":            "lillian",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"nonce":          nonce,
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"groups":         []string{"sales", "staff"},
+	}
+}
+
+func newTestAuthenticator(p *mockProvider) *OidcAuthenticator {
+	return NewAuthenticator(Config{
+		IssuerURL:    p.URL,
+		ClientID:     "lillian",
+		ClientSecret: "secret",
+		RedirectURL:  "https://lillian.example.com/api/auth/oidc/callback",
+		RolesClaim:   "groups",
+		RoleMapping:  map[string]string{"sales": "events:ro"},
+	})
+}
+
+func TestLoginFlow(t *testing.T) {
+	p := newMockProvider(t)
+	defer p.Close()
+	a := newTestAuthenticator(p)
+	ctx := context.Background()
+
+	verifier, _ := RandomString()
+	authURL, err := a.AuthCodeURL(ctx, "state", "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if !strings.HasPrefix(authURL, p.URL+"/authorize?") || q.Get("state") != "state" || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("expected authorize url with state and S256 challenge; received %s", authURL)
+	}
+	p.challenge = q.Get("code_challenge")
+	p.claims = p.validClaims("nonce")
+
+	if _, err := a.Exchange(ctx, "good-code", "wrong-verifier"); err == nil {
+		t.Fatalf("expected exchange with wrong verifier to fail")
+	}
+
+	raw, err := a.Exchange(ctx, "good-code", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := a.Verify(ctx, raw, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := a.Identity(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Username != "jane@example.com" {
+		t.Fatalf("expected username jane@example.com; received %s", id.Username)
+	}
+	if len(id.Roles) != 1 || id.Roles[0] != "events:ro" {
+		t.Fatalf("expected roles [events:ro]; received %v", id.Roles)
+	}
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	p := newMockProvider(t)
+	defer p.Close()
+	a := newTestAuthenticator(p)
+	ctx := context.Background()
+
+	cases := map[string]func(c map[string]interface{}){
+		"expired":   func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"audience":  func(c map[string]interface{}) { c["aud"] = "someone-else" },
+		"issuer":    func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"nonce":     func(c map[string]interface{}) { c["nonce"] = "replayed" },
+		"no expiry": func(c map[string]interface{}) { delete(c, "exp") },
+	}
+	for name, modify := range cases {
+		claims := p.validClaims("nonce")
+		modify(claims)
+		if _, err := a.Verify(ctx, p.sign(t, claims), "nonce"); err == nil {
+			t.Fatalf("expected %s check to fail", name)
+		}
+	}
+
+	raw := p.sign(t, p.validClaims("nonce"))
+	parts := strings.Split(raw, ".")
+	tampered := p.validClaims("nonce")
+	tampered["email"] = "admin@example.com"
+	b, _ := json.Marshal(tampered)
+	forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString(b) + "." + parts[2]
+	if _, err := a.Verify(ctx, forged, "nonce"); err != ErrInvalidIDToken {
+		t.Fatalf("expected %s for a tampered token; received %v", ErrInvalidIDToken, err)
+	}
+
+	if _, err := a.Verify(ctx, raw, "nonce"); err != nil {
+		t.Fatalf("expected valid token to verify: %s", err)
+	}
+}
+
+func TestIdentityDefaults(t *testing.T) {
+	a := NewAuthenticator(Config{DefaultRoles: []string{"events:ro"}})
+
+	if _, err := a.Identity(Claims{"sub": "123"}); err != ErrNoUsernameClaim {
+		t.Fatalf("expected %s; received %v", ErrNoUsernameClaim, err)
+	}
+
+	id, err := a.Identity(Claims{"email": "joe@example.com", "email_verified": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(id.Roles) != 1 || id.Roles[0] != "events:ro" {
+		t.Fatalf("expected default roles; received %v", id.Roles)
+	}
+}
+
+func TestIdentityRestrictions(t *testing.T) {
+	a := NewAuthenticator(Config{
+		RolesClaim:     "groups",
+		AllowedDomains: []string{"example.com"},
+		AllowedGroups:  []string{"staff"},
+	})
+	staff := []interface{}{"staff"}
+
+	tests := []struct {
+		claims Claims
+		err    error
+	}{
+		{Claims{"email": "joe@example.com", "groups": staff}, ErrEmailNotVerified},
+		{Claims{"email": "joe@example.com", "email_verified": "true", "groups": staff}, ErrEmailNotVerified},
+		{Claims{"email": "joe@evil.com", "email_verified": true, "groups": staff}, ErrDomainNotAllowed},
+		{Claims{"email": "joe@example.com.evil.com", "email_verified": true, "groups": staff}, ErrDomainNotAllowed},
+		{Claims{"email": "joe@example.com", "email_verified": true, "groups": []interface{}{"sales"}}, ErrGroupNotAllowed},
+		{Claims{"email": "joe@example.com", "email_verified": true}, ErrGroupNotAllowed},
+		{Claims{"email": "joe@Example.COM", "email_verified": true, "groups": staff}, nil},
+	}
+	for _, test := range tests {
+		if _, err := a.Identity(test.claims); err != test.err {
+			t.Fatalf("%v: expected %v; received %v", test.claims, test.err, err)
+		}
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	p := newMockProvider(t)
+	defer p.Close()
+
+	a := NewAuthenticator(Config{IssuerURL: p.URL + "/tenant"})
+	if _, err := a.Discover(context.Background()); err == nil {
+		t.Fatalf("expected discovery to fail for a different issuer")
+	}
+}
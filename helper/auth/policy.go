@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"fmt"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordError is returned when a password violates the policy, so
+// callers can tell it apart from storage errors
+type PasswordError struct {
+	Message string
+}
+
+func (e *PasswordError) Error() string {
+	return e.Message
+}
+
+var (
+	ErrPasswordNoUpper  = &PasswordError{"密码需要包含大写字母"}
+	ErrPasswordNoLower  = &PasswordError{"密码需要包含小写字母"}
+	ErrPasswordNoDigit  = &PasswordError{"密码需要包含数字"}
+	ErrPasswordNoSymbol = &PasswordError{"密码需要包含特殊字符"}
+	ErrPasswordReused   = &PasswordError{"不能使用最近用过的密码"}
+)
+
+// PasswordPolicy describes the rules a new password has to satisfy.
+// History is the number of previous passwords that may not be reused.
+type PasswordPolicy struct {
+	MinLength     int  `json:"min_length,omitempty"`
+	RequireUpper  bool `json:"require_upper,omitempty"`
+	RequireLower  bool `json:"require_lower,omitempty"`
+	RequireDigit  bool `json:"require_digit,omitempty"`
+	RequireSymbol bool `json:"require_symbol,omitempty"`
+	History       int  `json:"history,omitempty"`
+}
+
+// Validate checks the length and character classes of a password
+func (p PasswordPolicy) Validate(password string) error {
+	if n := len([]rune(password)); n < p.MinLength {
+		return &PasswordError{fmt.Sprintf("密码长度至少为 %d 个字符", p.MinLength)}
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c) || unicode.IsSpace(c):
+			symbol = true
+		}
+	}
+
+	switch {
+	case p.RequireUpper && !upper:
+		return ErrPasswordNoUpper
+	case p.RequireLower && !lower:
+		return ErrPasswordNoLower
+	case p.RequireDigit && !digit:
+		return ErrPasswordNoDigit
+	case p.RequireSymbol && !symbol:
+		return ErrPasswordNoSymbol
+	}
+	return nil
+}
+
+// CheckReuse returns ErrPasswordReused if the password matches one of the
+// given bcrypt hashes
+func (p PasswordPolicy) CheckReuse(password string, hashes []string) error {
+	for _, h := range hashes {
+		if h == "" {
+			continue
+		}
+		if bcrypt.CompareHashAndPassword([]byte(h), []byte(password)) == nil {
+			return ErrPasswordReused
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	p := PasswordPolicy{
+		MinLength:     8,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+	}
+
+	if err := p.Validate("Sh0rt!"); err == nil {
+		t.Fatal("expected error for short password")
+	}
+
+	cases := map[string]*PasswordError{
+		"nouppercase1!": ErrPasswordNoUpper,
+		"NOLOWERCASE1!": ErrPasswordNoLower,
+		"NoDigitsHere!": ErrPasswordNoDigit,
+		"NoSymbols123":  ErrPasswordNoSymbol,
+		"外贸Crm2017!":    nil,
+	}
+
+	for password, expected := range cases {
+		err := p.Validate(password)
+		if expected == nil && err != nil {
+			t.Fatalf("expected no error for %s; received %v", password, err)
+		}
+		if expected != nil && err != error(expected) {
+			t.Fatalf("expected %v for %s; received %v", expected, password, err)
+		}
+	}
+}
+
+func TestPasswordPolicyCheckReuse(t *testing.T) {
+	h, err := Hash(testPass)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := PasswordPolicy{History: 3}
+	if err := p.CheckReuse(testPass, []string{"", h}); err != ErrPasswordReused {
+		t.Fatalf("expected ErrPasswordReused; received %v", err)
+	}
+	if err := p.CheckReuse("another"+testPass, []string{h}); err != nil {
+		t.Fatalf("expected no error; received %s", err)
+	}
+}
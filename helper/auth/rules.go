@@ -75,6 +75,30 @@ func DefaultACLs() []*ACL {
 	}
 	acls = append(acls, eventsACLRW)
 
+	extensionsACLRO := &ACL{
+		RoleName:    "extensions:ro",
+		Description: "Extensions Read Only",
+		Rules: []*AccessRule{
+			{
+				Path:    "/api/extensions",
+				Methods: []string{"GET"},
+			},
+		},
+	}
+	acls = append(acls, extensionsACLRO)
+
+	extensionsACLRW := &ACL{
+		RoleName:    "extensions:rw",
+		Description: "Extensions",
+		Rules: []*AccessRule{
+			{
+				Path:    "/api/extensions",
+				Methods: []string{"GET", "POST", "PUT", "DELETE"},
+			},
+		},
+	}
+	acls = append(acls, extensionsACLRW)
+
 	imagesACLRO := &ACL{
 		RoleName:    "images:ro",
 		Description: "Images Read Only",
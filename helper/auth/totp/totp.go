@@ -0,0 +1,156 @@
+// Package totp implements time-based one-time passwords (RFC 6238) as
+// used by authenticator apps, plus one-time recovery codes.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits is the length of the generated codes
+	Digits = 6
+	// Period is the number of seconds a code stays valid
+	Period = 30
+	// Skew is the number of periods before and after the current one
+	// that are still accepted, to allow for clock drift
+	Skew = 1
+
+	secretSize       = 20
+	recoveryCodeSize = 10
+)
+
+var (
+	ErrInvalidSecret = errors.New("无效的两步验证密钥")
+
+	encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// GenerateSecret returns a random base32 encoded secret
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(strings.TrimSpace(secret), " ", "", -1))
+	key, err := encoding.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
+
+// URI returns the otpauth:// URI an authenticator app imports, usually
+// by scanning it as a QR code
+func URI(secret, issuer, account string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(Period))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// hotp computes the RFC 4226 code for the counter
+func hotp(key []byte, counter uint64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, bin%mod)
+}
+
+// Counter returns the time step a code for t belongs to
+func Counter(t time.Time) uint64 {
+	return uint64(t.Unix() / Period)
+}
+
+// Code returns the code for the secret at time t
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, Counter(t), Digits), nil
+}
+
+// Validate reports whether code is valid for the secret at time t and
+// returns the time step it matched, so callers can reject replays
+func Validate(code, secret string, t time.Time) (uint64, bool) {
+	code = strings.Replace(strings.TrimSpace(code), " ", "", -1)
+	if len(code) != Digits {
+		return 0, false
+	}
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	counter := Counter(t)
+	for i := -Skew; i <= Skew; i++ {
+		c := counter + uint64(i)
+		if subtle.ConstantTimeCompare([]byte(hotp(key, c, Digits)), []byte(code)) == 1 {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCodes returns n random single use codes formatted as
+// xxxxx-xxxxx
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		b := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(b))[:recoveryCodeSize]
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode returns the stored form of a recovery code. The codes
+// are random, so a plain SHA-256 is enough and keeps lookups cheap.
+// Formatting users tend to add or drop when typing a code is ignored.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.Replace(code, " ", "", -1)
+	code = strings.Replace(code, "-", "", -1)
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
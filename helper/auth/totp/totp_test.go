@@ -0,0 +1,105 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B, SHA1 with the ASCII key "12345678901234567890"
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestRFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for _, v := range rfcVectors {
+		if code := hotp(key, Counter(time.Unix(v.unix, 0)), 8); code != v.code {
+			t.Fatalf("expected %s at %d; received %s", v.code, v.unix, code)
+		}
+	}
+}
+
+func TestCodeAndValidate(t *testing.T) {
+	secret := encoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111109, 0)
+
+	code, err := Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "081804" {
+		t.Fatalf("expected 081804; received %s", code)
+	}
+
+	if _, ok := Validate(code, secret, now.Add(Period*time.Second)); !ok {
+		t.Fatalf("expected code to be accepted one period later")
+	}
+	if _, ok := Validate(code, secret, now.Add(3*Period*time.Second)); ok {
+		t.Fatalf("expected code to be rejected three periods later")
+	}
+	if _, ok := Validate("000000", secret, now); ok {
+		t.Fatalf("expected wrong code to be rejected")
+	}
+	if _, ok := Validate(code, "not base32!", now); ok {
+		t.Fatalf("expected invalid secret to be rejected")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 32 {
+		t.Fatalf("expected 32 characters; received %d", len(secret))
+	}
+	if _, err := Code(secret, time.Now()); err != nil {
+		t.Fatalf("expected generated secret to be usable: %s", err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("ABCDEF", "lillian", "admin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/lillian:admin" {
+		t.Fatalf("expected otpauth://totp/lillian:admin; received %s", u)
+	}
+	if s := u.Query().Get("secret"); s != "ABCDEF" {
+		t.Fatalf("expected secret ABCDEF; received %s", s)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("expected 10 codes; received %d", len(codes))
+	}
+
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if seen[c] {
+			t.Fatalf("expected unique codes; received %s twice", c)
+		}
+		seen[c] = true
+	}
+
+	code := codes[0]
+	if HashRecoveryCode(code) != HashRecoveryCode(" "+strings.ToUpper(strings.Replace(code, "-", "", -1))) {
+		t.Fatalf("expected formatting to be ignored for %s", code)
+	}
+}
@@ -0,0 +1,103 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatCSV, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader([]string{"username", "roles"})
+	w.WriteRow([]string{"admin", "admin,events:ro"})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "\xef\xbb\xbfusername,roles\nadmin,\"admin,events:ro\"\n"
+	if buf.String() != expected {
+		t.Fatalf("expected %q; received %q", expected, buf.String())
+	}
+}
+
+func TestCSVFormulaEscaping(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatCSV, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader([]string{"message"})
+	for _, v := range []string{"=HYPERLINK(\"http://evil\")", "+1", "-2", "@SUM(A1)", "\tcmd", "a=b", "-"} {
+		w.WriteRow([]string{v})
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "\xef\xbb\xbfmessage\n\"'=HYPERLINK(\"\"http://evil\"\")\"\n'+1\n'-2\n'@SUM(A1)\n'\tcmd\na=b\n'-\n"
+	if buf.String() != expected {
+		t.Fatalf("expected %q; received %q", expected, buf.String())
+	}
+}
+
+func TestXLSXWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatXLSX, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader([]string{"name"})
+	w.WriteRow([]string{"<李>&"})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sheet string
+	for _, f := range zr.File {
+		if f.Name != "xl/worksheets/sheet1.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sheet = string(b)
+	}
+
+	if !strings.Contains(sheet, `<c r="A2" t="inlineStr"><is><t xml:space="preserve">&lt;李&gt;&amp;</t></is></c>`) {
+		t.Fatalf("expected escaped cell in sheet; received %s", sheet)
+	}
+}
+
+func TestUnsupportedFormat(t *testing.T) {
+	if _, err := NewWriter("pdf", &bytes.Buffer{}); err != ErrUnsupportedFormat {
+		t.Fatalf("expected ErrUnsupportedFormat; received %v", err)
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for i, expected := range map[int]string{0: "A", 25: "Z", 26: "AA", 701: "ZZ", 702: "AAA"} {
+		if n := columnName(i); n != expected {
+			t.Fatalf("expected column %s for %d; received %s", expected, i, n)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistryOutput(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("test_requests_total", "Requests.", "route", "code")
+	latency := r.NewHistogramVec("test_latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	r.NewGaugeFunc("test_open", "Open.", func() float64 { return 3 })
+
+	requests.Inc("/api/accounts", "200")
+	requests.Inc("/api/accounts", "200")
+	latency.Observe(0.05, "/api/accounts")
+	latency.Observe(0.5, "/api/accounts")
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, expected := range []string{
+		"# TYPE test_requests_total counter\n",
+		`test_requests_total{route="/api/accounts",code="200"} 2` + "\n",
+		"# TYPE test_latency_seconds histogram\n",
+		`test_latency_seconds_bucket{route="/api/accounts",le="0.1"} 1` + "\n",
+		`test_latency_seconds_bucket{route="/api/accounts",le="1"} 2` + "\n",
+		`test_latency_seconds_bucket{route="/api/accounts",le="+Inf"} 2` + "\n",
+		`test_latency_seconds_sum{route="/api/accounts"} 0.55` + "\n",
+		`test_latency_seconds_count{route="/api/accounts"} 2` + "\n",
+		"test_open 3\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("expected %q in output:\n%s", expected, out)
+		}
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_escaped_total", "Escaped \\ help\nline.", "value").Inc("a\\b\"c\nd\té")
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, expected := range []string{
+		`# HELP test_escaped_total Escaped \\ help\nline.` + "\n",
+		`test_escaped_total{value="a\\b\"c\nd` + "\t" + `é"} 1` + "\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("expected %q in output:\n%s", expected, out)
+		}
+	}
+}
+
+func TestDuplicateMetric(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("dup_total", "Dup.")
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic registering duplicate metric")
+		}
+	}()
+	r.NewCounterVec("dup_total", "Dup.")
+}
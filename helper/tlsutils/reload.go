@@ -0,0 +1,137 @@
+package tlsutils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// CertificateReloader serves a certificate through tls.Config.GetCertificate
+// and replaces it when the certificate files change. A pair that fails to
+// load never replaces the certificate currently being served.
+type CertificateReloader struct {
+	certPath string
+	keyPath  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+
+	// OnReload is called after every reload attempt with the parsed
+	// leaf certificate, or the error that kept the old one in place
+	OnReload func(leaf *x509.Certificate, err error)
+}
+
+// NewCertificateReloader loads the initial certificate pair
+func NewCertificateReloader(certPath, keyPath string) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+	if _, err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertificateReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, p := range []string{r.certPath, r.keyPath} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *CertificateReloader) load() (*x509.Certificate, error) {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf = leaf
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+
+	return leaf, nil
+}
+
+// Reload reads the certificate pair again. On error the previous
+// certificate keeps being served.
+func (r *CertificateReloader) Reload() error {
+	leaf, err := r.load()
+	if err != nil {
+		log.Errorf("error reloading tls certificate, keeping current one: cert=%s err=%s", r.certPath, err)
+	} else {
+		log.Infof("reloaded tls certificate: cert=%s serial=%s expires=%s", r.certPath, leaf.SerialNumber, leaf.NotAfter)
+	}
+	if r.OnReload != nil {
+		r.OnReload(leaf, err)
+	}
+	return err
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *CertificateReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Certificate returns the certificate currently being served
+func (r *CertificateReloader) Certificate() *tls.Certificate {
+	cert, _ := r.GetCertificate(nil)
+	return cert
+}
+
+// Watch polls the certificate files every interval and reloads them when
+// they change, until stop is closed
+func (r *CertificateReloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			modTime, err := r.filesModTime()
+			if err != nil {
+				log.Debugf("error checking tls certificate: %s", err)
+				continue
+			}
+
+			r.mu.RLock()
+			changed := modTime.After(r.modTime)
+			r.mu.RUnlock()
+
+			if changed {
+				// remember the attempt so a broken pair is not retried on every tick
+				r.mu.Lock()
+				r.modTime = modTime
+				r.mu.Unlock()
+				r.Reload()
+			}
+		}
+	}
+}
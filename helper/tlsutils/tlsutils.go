@@ -2,6 +2,9 @@ package tlsutils
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -20,13 +23,29 @@ import (
 )
 
 var (
-	ErrNotRSAPrivateKey = errors.New("private key is not an RSA key")
+	ErrUnsupportedPrivateKey = errors.New("private key is not an RSA or ECDSA key")
+	ErrUnsupportedKeyType    = errors.New("key type must be rsa or ecdsa")
+	ErrNoClientCA            = errors.New("verifying client certificates needs a ca certificate")
 )
 
 const (
 	systemCertPath = "/etc/ssl"
+
+	KeyTypeRSA   = "rsa"
+	KeyTypeECDSA = "ecdsa"
 )
 
+// CertOptions describes a certificate to generate. Bits is the RSA key
+// size, or for ECDSA the curve size (256, 384 or 521).
+type CertOptions struct {
+	Org        string
+	CommonName string
+	Hosts      []string
+	Client     bool
+	KeyType    string
+	Bits       int
+}
+
 func loadSystemCertificates(certPool *x509.CertPool) error {
 	if _, err := os.Stat(systemCertPath); os.IsNotExist(err) {
 		return nil
@@ -48,8 +67,11 @@ func loadSystemCertificates(certPool *x509.CertPool) error {
 }
 
 // GetServerTLSConfig returns a TLS config for using with ListenAndServeTLS
-// This sets up the Root and Client CAs for verification
-func GetServerTLSConfig(caCert, serverCert, serverKey []byte, allowInsecure bool) (*tls.Config, error) {
+// The system roots and caCert are trusted for outgoing connections. When
+// verifyClients is set clients must present a certificate signed by
+// caCert, and only by caCert: a publicly trusted certificate must not
+// log anybody in. Otherwise no client certificate is requested.
+func GetServerTLSConfig(caCert, serverCert, serverKey []byte, allowInsecure, verifyClients bool) (*tls.Config, error) {
 	// TLS config
 	var tlsConfig tls.Config
 	tlsConfig.InsecureSkipVerify = allowInsecure
@@ -64,12 +86,18 @@ func GetServerTLSConfig(caCert, serverCert, serverKey []byte, allowInsecure bool
 	certPool.AppendCertsFromPEM(caCert)
 
 	tlsConfig.RootCAs = certPool
-	tlsConfig.ClientCAs = certPool
 
 	log.Debugf("tls root CAs: %d", len(tlsConfig.RootCAs.Subjects()))
 
-	// require client auth
-	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	tlsConfig.ClientAuth = tls.NoClientCert
+	if verifyClients {
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caCert) {
+			return nil, ErrNoClientCA
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
 
 	// server cert
 	keypair, err := tls.X509KeyPair(serverCert, serverKey)
@@ -114,54 +142,121 @@ func newCertificate(org string) (*x509.Certificate, error) {
 
 }
 
+func generateKey(keyType string, bits int) (crypto.Signer, error) {
+	switch keyType {
+	case "", KeyTypeRSA:
+		return rsa.GenerateKey(rand.Reader, bits)
+	case KeyTypeECDSA:
+		curve := elliptic.P256()
+		switch bits {
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	}
+	return nil, ErrUnsupportedKeyType
+}
+
+func encodeKey(priv crypto.Signer) (*pem.Block, error) {
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
+	case *ecdsa.PrivateKey:
+		b, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}, nil
+	}
+	return nil, ErrUnsupportedPrivateKey
+}
+
+func encodeCert(derBytes []byte, priv crypto.Signer) ([]byte, []byte, error) {
+	keyBlock, err := encodeKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var certOut bytes.Buffer
+	var keyOut bytes.Buffer
+
+	pem.Encode(&certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	pem.Encode(&keyOut, keyBlock)
+
+	return certOut.Bytes(), keyOut.Bytes(), nil
+}
+
 // GenerateCACertificate generates a new certificate authority from the specified org
 // and bit size and returns the certificate and key as []byte, []byte
 func GenerateCACertificate(org string, bits int) ([]byte, []byte, error) {
-	template, err := newCertificate(org)
+	return GenerateCA(CertOptions{
+		Org:     org,
+		KeyType: KeyTypeRSA,
+		Bits:    bits,
+	})
+}
+
+// GenerateCA generates a new certificate authority using either an RSA
+// or an ECDSA key and returns the certificate and key as []byte, []byte
+func GenerateCA(opts CertOptions) ([]byte, []byte, error) {
+	template, err := newCertificate(opts.Org)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	template.Subject.CommonName = opts.CommonName
 	template.IsCA = true
 	template.KeyUsage |= x509.KeyUsageCertSign
 	template.KeyUsage |= x509.KeyUsageKeyEncipherment
 	template.KeyUsage |= x509.KeyUsageKeyAgreement
 
-	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	priv, err := generateKey(opts.KeyType, opts.Bits)
 	if err != nil {
 		return nil, nil, err
 	}
+	if opts.KeyType == KeyTypeECDSA {
+		template.KeyUsage &^= x509.KeyUsageKeyEncipherment
+	}
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, priv.Public(), priv)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	var certOut bytes.Buffer
-	var keyOut bytes.Buffer
-
-	pem.Encode(&certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
-	pem.Encode(&keyOut, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
-
-	return certOut.Bytes(), keyOut.Bytes(), nil
+	return encodeCert(derBytes, priv)
 }
 
 // GenerateCert generates a new certificate signed using the provided
 // certificate authority certificate and key byte arrays.  It will return
 // the generated certificate and key as []byte, []byte
 func GenerateCert(hosts []string, caCert []byte, caKey []byte, org string, bits int) ([]byte, []byte, error) {
-	template, err := newCertificate(org)
+	return GenerateSignedCert(CertOptions{
+		Org:     org,
+		Hosts:   hosts,
+		Client:  len(hosts) == 1 && hosts[0] == "",
+		KeyType: KeyTypeRSA,
+		Bits:    bits,
+	}, caCert, caKey)
+}
+
+// GenerateSignedCert generates a client or server certificate signed by
+// the provided certificate authority. Server certificates are valid for
+// opts.Hosts, client certificates identify opts.CommonName.
+func GenerateSignedCert(opts CertOptions, caCert []byte, caKey []byte) ([]byte, []byte, error) {
+	template, err := newCertificate(opts.Org)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// client
-	if len(hosts) == 1 && hosts[0] == "" {
+	template.Subject.CommonName = opts.CommonName
+	if opts.Client {
 		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
 		template.KeyUsage = x509.KeyUsageDigitalSignature
 	} else { // server
 		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
-		for _, h := range hosts {
+		for _, h := range opts.Hosts {
 			if ip := net.ParseIP(h); ip != nil {
 				template.IPAddresses = append(template.IPAddresses, ip)
 			} else {
@@ -175,35 +270,54 @@ func GenerateCert(hosts []string, caCert []byte, caKey []byte, org string, bits
 		return nil, nil, err
 	}
 
-	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	priv, err := generateKey(opts.KeyType, opts.Bits)
 	if err != nil {
 		return nil, nil, err
 	}
+	if opts.KeyType == KeyTypeECDSA {
+		template.KeyUsage &^= x509.KeyUsageKeyEncipherment
+	}
 
 	x509Cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
 	if err != nil {
 		return nil, nil, err
 	}
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, template, x509Cert, &priv.PublicKey, tlsCert.PrivateKey)
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, x509Cert, priv.Public(), tlsCert.PrivateKey)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	var certOut bytes.Buffer
-	var keyOut bytes.Buffer
+	return encodeCert(derBytes, priv)
+}
 
-	pem.Encode(&certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
-	pem.Encode(&keyOut, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+// WriteCertificate writes the PEM encoded certificate and key to
+// dir/name.pem and dir/name-key.pem. The key is only readable by the owner.
+func WriteCertificate(dir, name string, cert, key []byte) (string, string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
 
-	return certOut.Bytes(), keyOut.Bytes(), nil
+	certPath := filepath.Join(dir, name+".pem")
+	keyPath := filepath.Join(dir, name+"-key.pem")
+
+	if err := ioutil.WriteFile(certPath, cert, 0644); err != nil {
+		return "", "", err
+	}
+	if err := ioutil.WriteFile(keyPath, key, 0600); err != nil {
+		return "", "", err
+	}
+	return certPath, keyPath, nil
 }
 
-// GetPublicKey returns the RSA public key for the specified private key
-func GetPublicKey(priv interface{}) (*rsa.PublicKey, error) {
-	if k, ok := priv.(*rsa.PrivateKey); ok {
+// GetPublicKey returns the RSA or ECDSA public key for the specified private key
+func GetPublicKey(priv interface{}) (crypto.PublicKey, error) {
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey, nil
+	case *ecdsa.PrivateKey:
 		return &k.PublicKey, nil
 	}
 
-	return nil, ErrNotRSAPrivateKey
+	return nil, ErrUnsupportedPrivateKey
 }
@@ -3,6 +3,8 @@ package tlsutils
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"io/ioutil"
+	"os"
 	"testing"
 )
 
@@ -90,6 +92,41 @@ func TestGenerateCert(t *testing.T) {
 	}
 }
 
+func TestGetServerTLSConfig(t *testing.T) {
+	caCert, caKey, err := GenerateCACertificate(testOrg, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key, err := GenerateCert([]string{"localhost"}, caCert, caKey, testOrg, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := GetServerTLSConfig(caCert, cert, key, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientAuth != tls.NoClientCert || config.ClientCAs != nil {
+		t.Fatalf("expected no client certificates without verifyClients; got %v", config.ClientAuth)
+	}
+
+	config, err = GetServerTLSConfig(caCert, cert, key, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("expected client certificates to be required; got %v", config.ClientAuth)
+	}
+	// only the configured ca may sign client certificates
+	if n := len(config.ClientCAs.Subjects()); n != 1 {
+		t.Fatalf("expected 1 client ca; got %d", n)
+	}
+
+	if _, err := GetServerTLSConfig(nil, cert, key, false, true); err != ErrNoClientCA {
+		t.Fatalf("expected ErrNoClientCA; got %v", err)
+	}
+}
+
 func TestGetPublicKey(t *testing.T) {
 	caCert, caKey, err := GenerateCACertificate(testOrg, bits)
 	if err != nil {
@@ -126,3 +163,126 @@ func TestGetPublicKey(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestGenerateECDSACert(t *testing.T) {
+	caCert, caKey, err := GenerateCA(CertOptions{Org: testOrg, KeyType: KeyTypeECDSA, Bits: 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, key, err := GenerateSignedCert(CertOptions{
+		Org:        testOrg,
+		CommonName: "billing-service",
+		Client:     true,
+		KeyType:    KeyTypeECDSA,
+		Bits:       384,
+	}, caCert, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keypair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := x509.ParseCertificate(keypair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Subject.CommonName != "billing-service" {
+		t.Fatalf("expected cn billing-service; received %s", c.Subject.CommonName)
+	}
+
+	if c.PublicKeyAlgorithm != x509.ECDSA {
+		t.Fatalf("expected ECDSA key; received %s", c.PublicKeyAlgorithm)
+	}
+
+	if _, err := GetPublicKey(keypair.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := tls.X509KeyPair(caCert, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caX509, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CheckSignatureFrom(caX509); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateCertUnsupportedKeyType(t *testing.T) {
+	if _, _, err := GenerateCA(CertOptions{Org: testOrg, KeyType: "dsa"}); err != ErrUnsupportedKeyType {
+		t.Fatalf("expected ErrUnsupportedKeyType; received %v", err)
+	}
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lillian-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caCert, caKey, err := GenerateCA(CertOptions{Org: testOrg, KeyType: KeyTypeECDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCert := func() ([]byte, []byte) {
+		cert, key, err := GenerateSignedCert(CertOptions{Org: testOrg, Hosts: []string{"localhost"}, KeyType: KeyTypeECDSA}, caCert, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+
+	cert, key := newCert()
+	certPath, keyPath, err := WriteCertificate(dir, "server", cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewCertificateReloader(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloads := 0
+	r.OnReload = func(leaf *x509.Certificate, err error) {
+		reloads++
+	}
+	original := r.Certificate()
+
+	// a broken pair keeps the current certificate
+	if err := ioutil.WriteFile(certPath, []byte("invalid"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected error reloading invalid certificate")
+	}
+	if r.Certificate() != original {
+		t.Fatal("expected original certificate after failed reload")
+	}
+
+	cert, key = newCert()
+	if _, _, err := WriteCertificate(dir, "server", cert, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	current, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current == original {
+		t.Fatal("expected new certificate after reload")
+	}
+	if reloads != 2 {
+		t.Fatalf("expected 2 reload callbacks; received %d", reloads)
+	}
+}
@@ -6,18 +6,19 @@ import (
 	"github.com/nicle-lin/lillian/controller/server"
 	"github.com/nicle-lin/lillian/version"
 	"os"
+	"runtime/debug"
 )
 
 const STORE_KEY = "lillian"
 
 func main() {
 	defer func() {
-		if err := recover(); err != nil{
-			log.Infoln(err)
+		if err := recover(); err != nil {
+			log.Errorf("panic: %v\n%s", err, debug.Stack())
+			os.Exit(1)
 		}
 	}()
 
-
 	app := cli.NewApp()
 	app.Name = "lillian"
 	app.Usage = "lillian crm"
@@ -31,6 +32,28 @@ func main() {
 		return nil
 	}
 
+	certFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:  "dir,d",
+			Usage: "directory for certificates",
+			Value: "certs",
+		},
+		cli.StringFlag{
+			Name:  "org",
+			Usage: "organization",
+			Value: "lillian",
+		},
+		cli.StringFlag{
+			Name:  "key-type",
+			Usage: "key type: rsa or ecdsa",
+			Value: "rsa",
+		},
+		cli.IntFlag{
+			Name:  "bits",
+			Usage: "key size: rsa bits (default 2048) or ecdsa curve 256/384/521 (default 256)",
+		},
+	}
+
 	app.Commands = []cli.Command{
 		{
 			Name:   "server",
@@ -39,8 +62,128 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "listen,l",
-					Usage: "listen address",
-					Value: ":5525",
+					Usage: "listen address (overrides [app] host)",
+				},
+			},
+		},
+		{
+			Name:  "migrate",
+			Usage: "manage database schema migrations",
+			Subcommands: []cli.Command{
+				{
+					Name:   "up",
+					Usage:  "apply all pending migrations",
+					Action: server.MigrateUp,
+				},
+				{
+					Name:   "down",
+					Usage:  "revert applied migrations",
+					Action: server.MigrateDown,
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "steps",
+							Usage: "number of migrations to revert",
+							Value: 1,
+						},
+					},
+				},
+				{
+					Name:   "status",
+					Usage:  "show applied and pending migrations",
+					Action: server.MigrateStatus,
+				},
+			},
+		},
+		{
+			Name:  "accounts",
+			Usage: "manage accounts in the configured store",
+			Subcommands: []cli.Command{
+				{
+					Name:   "create",
+					Usage:  "create an account",
+					Action: server.AccountsCreate,
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "username,u", Usage: "username"},
+						cli.StringFlag{Name: "password,p", Usage: "password, read from stdin if empty, random and printed if none is given"},
+						cli.StringFlag{Name: "roles,r", Usage: "comma separated roles, e.g. admin"},
+						cli.StringFlag{Name: "first-name", Usage: "first name"},
+						cli.StringFlag{Name: "last-name", Usage: "last name"},
+					},
+				},
+				{
+					Name:   "list",
+					Usage:  "list accounts",
+					Action: server.AccountsList,
+				},
+				{
+					Name:   "set-password",
+					Usage:  "change the password of an account",
+					Action: server.AccountsSetPassword,
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "username,u", Usage: "username"},
+						cli.StringFlag{Name: "password,p", Usage: "new password, read from stdin if empty"},
+					},
+				},
+				{
+					Name:   "set-roles",
+					Usage:  "replace the roles of an account",
+					Action: server.AccountsSetRoles,
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "username,u", Usage: "username"},
+						cli.StringFlag{Name: "roles,r", Usage: "comma separated roles"},
+					},
+				},
+				{
+					Name:   "delete",
+					Usage:  "delete an account",
+					Action: server.AccountsDelete,
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "username,u", Usage: "username"},
+					},
+				},
+			},
+		},
+		{
+			Name:  "certs",
+			Usage: "manage TLS certificates",
+			Subcommands: []cli.Command{
+				{
+					Name:   "init-ca",
+					Usage:  "create a certificate authority",
+					Action: server.CertsInitCA,
+					Flags: append([]cli.Flag{
+						cli.BoolFlag{
+							Name:  "force",
+							Usage: "replace an existing CA",
+						},
+					}, certFlags...),
+				},
+				{
+					Name:   "server",
+					Usage:  "create a server certificate signed by the CA",
+					Action: server.CertsServer,
+					Flags: append([]cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "host",
+							Usage: "hostname or IP address, can be repeated",
+						},
+						cli.StringFlag{
+							Name:  "name",
+							Usage: "file name of the certificate",
+							Value: "server",
+						},
+					}, certFlags...),
+				},
+				{
+					Name:   "client",
+					Usage:  "create a client certificate signed by the CA",
+					Action: server.CertsClient,
+					Flags: append([]cli.Flag{
+						cli.StringFlag{
+							Name:  "cn",
+							Usage: "common name, the account username of the client",
+						},
+					}, certFlags...),
 				},
 			},
 		},
@@ -51,6 +194,11 @@ func main() {
 			Name:  "debug,D",
 			Usage: "enable debug",
 		},
+		cli.StringFlag{
+			Name:   "config,c",
+			Usage:  "path to config file (default config/config.ini)",
+			EnvVar: "LILLIAN_CONFIG",
+		},
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -0,0 +1,37 @@
+package model
+
+const (
+	ExtensionHookUI  = "ui"
+	ExtensionHookAPI = "api"
+)
+
+type Extension struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url,omitempty"`
+	// ServiceKey is sent to the extension with proxied requests and
+	// events; it is never part of the json, see the api for the one
+	// response that returns it
+	ServiceKey string           `json:"-"`
+	Events     []string         `json:"events,omitempty"`
+	Hooks      []*ExtensionHook `json:"hooks,omitempty"`
+}
+
+type ExtensionHook struct {
+	Type   string `json:"type,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Method string `json:"method,omitempty"`
+}
+
+// Subscribes reports whether the extension wants to receive events of
+// the given type; "*" subscribes to every event
+func (e *Extension) Subscribes(eventType string) bool {
+	for _, t := range e.Events {
+		if t == "*" || t == eventType {
+			return true
+		}
+	}
+	return false
+}